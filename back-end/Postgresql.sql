@@ -1,3 +1,21 @@
+CREATE TABLE locations (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    kind VARCHAR(10) NOT NULL CHECK (kind IN ('room', 'shelf', 'box', 'slot')),
+    parent_id integer REFERENCES locations(id),
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE sellers (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    kind VARCHAR(10) NOT NULL DEFAULT 'shop' CHECK (kind IN ('shop', 'online', 'market', 'person', 'other')),
+    url TEXT NOT NULL DEFAULT '',
+    notes TEXT NOT NULL DEFAULT ''
+);
+
+CREATE UNIQUE INDEX sellers_name_idx ON sellers (LOWER(name));
+
 CREATE TABLE vinyls (
     id SERIAL PRIMARY KEY,
     title VARCHAR(255) ,
@@ -12,9 +30,36 @@ CREATE TABLE vinyls (
     price DECIMAL(10, 2),
     description TEXT,
     currency VARCHAR(10),
-    status VARCHAR(10)
+    status VARCHAR(10),
+    barcode VARCHAR(32) NOT NULL DEFAULT '',
+    label VARCHAR(255) NOT NULL DEFAULT '',
+    catalog_number VARCHAR(64) NOT NULL DEFAULT '',
+    country VARCHAR(64) NOT NULL DEFAULT '',
+    release_date DATE,
+    rpm integer NOT NULL DEFAULT 0 CHECK (rpm IN (0, 33, 45, 78)),
+    disc_size integer NOT NULL DEFAULT 0 CHECK (disc_size IN (0, 7, 10, 12)),
+    color_variant VARCHAR(255) NOT NULL DEFAULT '',
+    limited_number integer NOT NULL DEFAULT 0,
+    limited_total integer NOT NULL DEFAULT 0,
+    media_condition VARCHAR(3) NOT NULL DEFAULT '',
+    sleeve_condition VARCHAR(3) NOT NULL DEFAULT '',
+    location_id integer REFERENCES locations(id),
+    seller_id integer REFERENCES sellers(id),
+    order_id VARCHAR(100) NOT NULL DEFAULT '',
+    shipping_cost DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    gift_from VARCHAR(255) NOT NULL DEFAULT '',
+    acquisition_state VARCHAR(4) NOT NULL DEFAULT '' CHECK (acquisition_state IN ('', 'new', 'used')),
+    disposed_at DATE,
+    sale_price DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    sale_currency VARCHAR(10) NOT NULL DEFAULT '',
+    disposed_to VARCHAR(255) NOT NULL DEFAULT '',
+    disposal_note TEXT NOT NULL DEFAULT ''
 );
 
+CREATE INDEX vinyls_barcode_idx ON vinyls (barcode);
+CREATE INDEX vinyls_catalog_number_idx ON vinyls (LOWER(catalog_number));
+CREATE INDEX vinyls_location_idx ON vinyls (location_id);
+
 CREATE TABLE users (
     id SERIAL PRIMARY KEY,
     username varchar(20) UNIQUE,
@@ -27,4 +72,160 @@ CREATE TABLE play (
     user_id integer REFERENCES users(id),
     play_time timestamp with time zone,
     status boolean
-);
\ No newline at end of file
+);
+
+CREATE TABLE condition_history (
+    id SERIAL PRIMARY KEY,
+    vinyl_id integer REFERENCES vinyls(id),
+    user_id integer REFERENCES users(id),
+    media_condition VARCHAR(3) NOT NULL DEFAULT '',
+    sleeve_condition VARCHAR(3) NOT NULL DEFAULT '',
+    note TEXT NOT NULL DEFAULT '',
+    changed_at timestamp with time zone DEFAULT NOW()
+);
+
+CREATE TABLE artists (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    sort_name VARCHAR(255) NOT NULL DEFAULT ''
+);
+
+CREATE UNIQUE INDEX artists_name_idx ON artists (LOWER(name));
+
+CREATE TABLE artist_aliases (
+    id SERIAL PRIMARY KEY,
+    artist_id integer NOT NULL REFERENCES artists(id) ON DELETE CASCADE,
+    alias VARCHAR(255) NOT NULL
+);
+
+CREATE UNIQUE INDEX artist_aliases_alias_idx ON artist_aliases (LOWER(alias));
+
+CREATE TABLE vinyl_artists (
+    vinyl_id integer NOT NULL REFERENCES vinyls(id),
+    artist_id integer NOT NULL REFERENCES artists(id),
+    role VARCHAR(32) NOT NULL DEFAULT 'main',
+    position integer NOT NULL DEFAULT 0,
+    PRIMARY KEY (vinyl_id, artist_id, role)
+);
+
+CREATE TABLE track_artists (
+    vinyl_id integer NOT NULL REFERENCES vinyls(id),
+    track_side VARCHAR(8) NOT NULL,
+    track_order integer NOT NULL,
+    artist_id integer NOT NULL REFERENCES artists(id),
+    role VARCHAR(32) NOT NULL DEFAULT 'featured',
+    PRIMARY KEY (vinyl_id, track_side, track_order, artist_id, role)
+);
+
+CREATE TABLE tags (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    kind VARCHAR(10) NOT NULL DEFAULT 'tag' CHECK (kind IN ('genre', 'style', 'tag')),
+    parent_id integer REFERENCES tags(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX tags_name_idx ON tags (kind, COALESCE(parent_id, 0), LOWER(name));
+
+CREATE TABLE vinyl_tags (
+    vinyl_id integer NOT NULL REFERENCES vinyls(id),
+    tag_id integer NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (vinyl_id, tag_id)
+);
+
+CREATE TABLE location_moves (
+    id SERIAL PRIMARY KEY,
+    vinyl_id integer NOT NULL REFERENCES vinyls(id),
+    from_location_id integer REFERENCES locations(id) ON DELETE SET NULL,
+    to_location_id integer REFERENCES locations(id) ON DELETE SET NULL,
+    user_id integer REFERENCES users(id),
+    note TEXT NOT NULL DEFAULT '',
+    moved_at timestamp with time zone DEFAULT NOW()
+);
+
+CREATE TABLE audit_sessions (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    location_id integer REFERENCES locations(id) ON DELETE SET NULL,
+    user_id integer REFERENCES users(id),
+    started_at timestamp with time zone DEFAULT NOW(),
+    closed_at timestamp with time zone,
+    report JSONB
+);
+
+CREATE TABLE audit_scans (
+    id SERIAL PRIMARY KEY,
+    session_id integer NOT NULL REFERENCES audit_sessions(id) ON DELETE CASCADE,
+    vinyl_id integer REFERENCES vinyls(id),
+    barcode VARCHAR(32) NOT NULL DEFAULT '',
+    location_id integer REFERENCES locations(id) ON DELETE SET NULL,
+    user_id integer REFERENCES users(id),
+    scanned_at timestamp with time zone DEFAULT NOW(),
+    UNIQUE (session_id, vinyl_id)
+);
+
+CREATE TABLE loans (
+    id SERIAL PRIMARY KEY,
+    vinyl_id integer NOT NULL REFERENCES vinyls(id),
+    borrower_name VARCHAR(255) NOT NULL,
+    borrower_contact VARCHAR(255) NOT NULL DEFAULT '',
+    lent_at DATE NOT NULL DEFAULT CURRENT_DATE,
+    due_at DATE,
+    returned_at DATE,
+    note TEXT NOT NULL DEFAULT '',
+    user_id integer REFERENCES users(id)
+);
+
+CREATE UNIQUE INDEX loans_open_idx ON loans (vinyl_id) WHERE returned_at IS NULL;
+
+CREATE TABLE wishlist (
+    id SERIAL PRIMARY KEY,
+    release JSONB NOT NULL,
+    target_price DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    currency VARCHAR(10) NOT NULL DEFAULT '',
+    priority integer NOT NULL DEFAULT 3 CHECK (priority BETWEEN 1 AND 5),
+    notes TEXT NOT NULL DEFAULT '',
+    user_id integer REFERENCES users(id),
+    added_at timestamp with time zone DEFAULT NOW(),
+    converted_at timestamp with time zone,
+    vinyl_id integer REFERENCES vinyls(id)
+);
+
+CREATE TABLE price_observations (
+    id SERIAL PRIMARY KEY,
+    vinyl_id integer NOT NULL REFERENCES vinyls(id),
+    observed_at DATE NOT NULL DEFAULT CURRENT_DATE,
+    value DECIMAL(10, 2) NOT NULL,
+    currency VARCHAR(10) NOT NULL,
+    source VARCHAR(255) NOT NULL DEFAULT '',
+    note TEXT NOT NULL DEFAULT '',
+    user_id integer REFERENCES users(id),
+    created_at timestamp with time zone DEFAULT NOW()
+);
+
+CREATE INDEX price_observations_vinyl_idx ON price_observations (vinyl_id, observed_at DESC);
+
+CREATE TABLE images (
+    hash CHAR(64) PRIMARY KEY,
+    ext VARCHAR(5) NOT NULL,
+    width integer NOT NULL,
+    height integer NOT NULL,
+    size_bytes integer NOT NULL,
+    palette TEXT[] NOT NULL DEFAULT '{}',
+    blurhash TEXT NOT NULL DEFAULT '',
+    phash BIGINT,
+    created_at timestamp with time zone DEFAULT NOW()
+);
+
+CREATE TABLE vinyl_images (
+    vinyl_id integer NOT NULL REFERENCES vinyls(id),
+    image_hash CHAR(64) NOT NULL REFERENCES images(hash),
+    kind VARCHAR(20) NOT NULL DEFAULT 'front' CHECK (kind IN ('front', 'back', 'inner_sleeve', 'label', 'insert', 'other')),
+    caption TEXT NOT NULL DEFAULT '',
+    position integer NOT NULL DEFAULT 0,
+    is_primary boolean NOT NULL DEFAULT false,
+    added_at timestamp with time zone DEFAULT NOW(),
+    PRIMARY KEY (vinyl_id, image_hash)
+);
+
+CREATE INDEX vinyl_images_hash_idx ON vinyl_images (image_hash);
+CREATE UNIQUE INDEX vinyl_images_primary_idx ON vinyl_images (vinyl_id) WHERE is_primary;
\ No newline at end of file
@@ -0,0 +1,746 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// ArtistCredit links an artist to a vinyl or a track
+type ArtistCredit struct {
+	ArtistID int    `json:"artist_id"`
+	Name     string `json:"name"`
+	Role     string `json:"role,omitempty"` // "main" on vinyls, "featured" on tracks by default
+}
+
+type Artist struct {
+	ID         int      `json:"id"`
+	Name       string   `json:"name"`
+	SortName   string   `json:"sort_name"`
+	Aliases    []string `json:"aliases"`
+	VinylCount int      `json:"vinyl_count"`
+	PlayCount  int      `json:"play_count"`
+}
+
+// errArtistNotFound is returned by resolveArtist for an unknown artist_id
+var errArtistNotFound = errors.New("artist not found")
+
+// findArtistByName matches a name against artist names and aliases, case-insensitively
+func findArtistByName(db dbExecutor, name string) (int, error) {
+	var id int
+	err := db.QueryRow(`SELECT id FROM artists WHERE LOWER(name) = LOWER($1)
+		UNION ALL
+		SELECT artist_id FROM artist_aliases WHERE LOWER(alias) = LOWER($1)
+		LIMIT 1`, name).Scan(&id)
+	return id, err
+}
+
+// resolveArtist returns the id of the credited artist, creating it if the name is new
+func resolveArtist(db dbExecutor, credit ArtistCredit) (int, error) {
+	if credit.ArtistID != 0 {
+		var id int
+		err := db.QueryRow("SELECT id FROM artists WHERE id = $1", credit.ArtistID).Scan(&id)
+		if err == sql.ErrNoRows {
+			return 0, errArtistNotFound
+		}
+		return id, err
+	}
+
+	name := strings.TrimSpace(credit.Name)
+	if name == "" {
+		return 0, fmt.Errorf("artist name cannot be empty")
+	}
+
+	id, err := findArtistByName(db, name)
+	if err == nil {
+		return id, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	err = db.QueryRow("INSERT INTO artists (name) VALUES ($1) RETURNING id", name).Scan(&id)
+	return id, err
+}
+
+// setVinylArtists replaces the artist links of a vinyl and its tracks. Without
+// explicit credits the free-text Artist field is linked as the main artist.
+func setVinylArtists(db dbExecutor, vinylID interface{}, vinyl Vinyl) error {
+	if err := setReleaseArtists(db, vinylID, vinyl.Artists, vinyl.Artist); err != nil {
+		return err
+	}
+	return setTrackArtists(db, vinylID, vinyl.Tracklist)
+}
+
+// setReleaseArtists replaces the release-level artist links of a vinyl,
+// linking the display credit as the main artist when credits is empty
+func setReleaseArtists(db dbExecutor, vinylID interface{}, credits []ArtistCredit, artist string) error {
+	if _, err := db.Exec("DELETE FROM vinyl_artists WHERE vinyl_id = $1", vinylID); err != nil {
+		return err
+	}
+
+	if len(credits) == 0 && strings.TrimSpace(artist) != "" {
+		credits = []ArtistCredit{{Name: artist}}
+	}
+	for i, credit := range credits {
+		artistID, err := resolveArtist(db, credit)
+		if err != nil {
+			return err
+		}
+		role := credit.Role
+		if role == "" {
+			role = "main"
+		}
+		_, err = db.Exec(`INSERT INTO vinyl_artists (vinyl_id, artist_id, role, position) VALUES ($1, $2, $3, $4)
+			ON CONFLICT DO NOTHING`, vinylID, artistID, role, i)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setTrackArtists replaces the featured artist links of every track of a vinyl
+func setTrackArtists(db dbExecutor, vinylID interface{}, tracks []Track) error {
+	if _, err := db.Exec("DELETE FROM track_artists WHERE vinyl_id = $1", vinylID); err != nil {
+		return err
+	}
+
+	for _, track := range tracks {
+		for _, credit := range track.Artists {
+			artistID, err := resolveArtist(db, credit)
+			if err != nil {
+				return err
+			}
+			role := credit.Role
+			if role == "" {
+				role = "featured"
+			}
+			_, err = db.Exec(`INSERT INTO track_artists (vinyl_id, track_side, track_order, artist_id, role) VALUES ($1, $2, $3, $4, $5)
+				ON CONFLICT DO NOTHING`, vinylID, track.Side, track.Order, artistID, role)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// storedArtistCredits returns the release-level artist links of a vinyl in order
+func storedArtistCredits(db dbExecutor, vinylID interface{}) ([]ArtistCredit, error) {
+	rows, err := db.Query("SELECT artist_id, role FROM vinyl_artists WHERE vinyl_id = $1 ORDER BY position", vinylID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	credits := []ArtistCredit{}
+	for rows.Next() {
+		var credit ArtistCredit
+		if err := rows.Scan(&credit.ArtistID, &credit.Role); err != nil {
+			return nil, err
+		}
+		credits = append(credits, credit)
+	}
+	return credits, rows.Err()
+}
+
+// sameArtistCredits reports whether requested credits are the stored links
+// sent back unchanged. Credits given by name only count as a change.
+func sameArtistCredits(requested, stored []ArtistCredit) bool {
+	if len(requested) != len(stored) {
+		return false
+	}
+	for i, credit := range requested {
+		role := credit.Role
+		if role == "" {
+			role = "main"
+		}
+		if credit.ArtistID == 0 || credit.ArtistID != stored[i].ArtistID || role != stored[i].Role {
+			return false
+		}
+	}
+	return true
+}
+
+// updateVinylArtists brings the artist links of an edited vinyl in line with
+// the request. Release credits that are missing or sent back unchanged are
+// kept, unless the display credit changed, in which case they are re-derived
+// from Artist. Track credits are replaced only when some track carries an
+// "artists" field; otherwise credits of tracks no longer in the tracklist are
+// dropped and the rest kept.
+func updateVinylArtists(db dbExecutor, vinylID interface{}, vinyl Vinyl, previousArtist string) error {
+	stored, err := storedArtistCredits(db, vinylID)
+	if err != nil {
+		return err
+	}
+	artistChanged := strings.TrimSpace(vinyl.Artist) != strings.TrimSpace(previousArtist)
+	switch {
+	case vinyl.Artists == nil || sameArtistCredits(vinyl.Artists, stored):
+		if artistChanged {
+			err = setReleaseArtists(db, vinylID, nil, vinyl.Artist)
+		}
+	default:
+		err = setReleaseArtists(db, vinylID, vinyl.Artists, vinyl.Artist)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, track := range vinyl.Tracklist {
+		if track.Artists != nil {
+			return setTrackArtists(db, vinylID, vinyl.Tracklist)
+		}
+	}
+	sides := make([]string, len(vinyl.Tracklist))
+	orders := make([]int64, len(vinyl.Tracklist))
+	for i, track := range vinyl.Tracklist {
+		sides[i], orders[i] = track.Side, int64(track.Order)
+	}
+	_, err = db.Exec(`DELETE FROM track_artists WHERE vinyl_id = $1 AND (track_side, track_order) NOT IN
+		(SELECT * FROM UNNEST($2::text[], $3::integer[]))`, vinylID, pq.Array(sides), pq.Array(orders))
+	return err
+}
+
+// linkVinylArtists runs updateVinylArtists for UpdateVinyl, writing
+// the error response itself. It reports whether the links were stored.
+func linkVinylArtists(c *gin.Context, db dbExecutor, vinylID interface{}, vinyl Vinyl, previousArtist string) bool {
+	err := updateVinylArtists(db, vinylID, vinyl, previousArtist)
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, errArtistNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown artist_id in artists"})
+		return false
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link artists"})
+	log.Println(err)
+	return false
+}
+
+// attachVinylArtists fills the Artists field of each vinyl
+func attachVinylArtists(db dbExecutor, vinyls []Vinyl) error {
+	if len(vinyls) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(vinyls))
+	index := make(map[int]int, len(vinyls))
+	for i := range vinyls {
+		ids[i] = int64(vinyls[i].ID)
+		index[vinyls[i].ID] = i
+		vinyls[i].Artists = []ArtistCredit{}
+	}
+
+	rows, err := db.Query(`SELECT va.vinyl_id, a.id, a.name, va.role
+		FROM vinyl_artists va
+		JOIN artists a ON a.id = va.artist_id
+		WHERE va.vinyl_id = ANY($1)
+		ORDER BY va.vinyl_id, va.position`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var vinylID int
+		var credit ArtistCredit
+		if err := rows.Scan(&vinylID, &credit.ArtistID, &credit.Name, &credit.Role); err != nil {
+			return err
+		}
+		i := index[vinylID]
+		vinyls[i].Artists = append(vinyls[i].Artists, credit)
+	}
+	return rows.Err()
+}
+
+// attachTrackArtists fills the Artists field of each track of a vinyl
+func attachTrackArtists(db dbExecutor, vinyl *Vinyl) error {
+	rows, err := db.Query(`SELECT ta.track_side, ta.track_order, a.id, a.name, ta.role
+		FROM track_artists ta
+		JOIN artists a ON a.id = ta.artist_id
+		WHERE ta.vinyl_id = $1
+		ORDER BY a.name`, vinyl.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var side string
+		var order int
+		var credit ArtistCredit
+		if err := rows.Scan(&side, &order, &credit.ArtistID, &credit.Name, &credit.Role); err != nil {
+			return err
+		}
+		for i := range vinyl.Tracklist {
+			if vinyl.Tracklist[i].Side == side && vinyl.Tracklist[i].Order == order {
+				vinyl.Tracklist[i].Artists = append(vinyl.Tracklist[i].Artists, credit)
+			}
+		}
+	}
+	return rows.Err()
+}
+
+// artistSummaryQuery selects artists with their aliases, distinct active vinyl count and play count
+const artistSummaryQuery = `
+	SELECT a.id, a.name, a.sort_name,
+		COALESCE((SELECT array_agg(al.alias ORDER BY al.alias) FROM artist_aliases al WHERE al.artist_id = a.id), '{}'),
+		(SELECT COUNT(DISTINCT v.id) FROM vinyls v
+			WHERE v.status = 'active' AND (
+				v.id IN (SELECT vinyl_id FROM vinyl_artists WHERE artist_id = a.id)
+				OR v.id IN (SELECT vinyl_id FROM track_artists WHERE artist_id = a.id))),
+		(SELECT COUNT(*) FROM play p
+			WHERE p.status = TRUE AND (
+				p.vinyl_id IN (SELECT vinyl_id FROM vinyl_artists WHERE artist_id = a.id)
+				OR p.vinyl_id IN (SELECT vinyl_id FROM track_artists WHERE artist_id = a.id)))
+	FROM artists a`
+
+func scanArtist(row rowScanner) (Artist, error) {
+	var a Artist
+	var aliases pq.StringArray
+	err := row.Scan(&a.ID, &a.Name, &a.SortName, &aliases, &a.VinylCount, &a.PlayCount)
+	a.Aliases = []string(aliases)
+	return a, err
+}
+
+// GetArtists lists artists with record and play counts, optionally filtered by name or alias
+func GetArtists(c *gin.Context) {
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	query := artistSummaryQuery
+	var args []interface{}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		query += ` WHERE a.name ILIKE $1 OR EXISTS (SELECT 1 FROM artist_aliases al WHERE al.artist_id = a.id AND al.alias ILIKE $1)`
+		args = append(args, "%"+q+"%")
+	}
+	query += " ORDER BY COALESCE(NULLIF(a.sort_name, ''), a.name) ASC"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve artists"})
+		log.Println(err)
+		return
+	}
+	defer rows.Close()
+
+	artists := []Artist{}
+	for rows.Next() {
+		a, err := scanArtist(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error scanning data"})
+			log.Println(err)
+			return
+		}
+		artists = append(artists, a)
+	}
+
+	cachedJSON(c, artists)
+}
+
+// GetArtistByID returns an artist with their records and plays
+func GetArtistByID(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	artist, err := scanArtist(db.QueryRow(artistSummaryQuery+" WHERE a.id = $1", id))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Artist not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve artist"})
+		log.Println(err)
+		return
+	}
+
+	// Records credited to the artist, either as a release artist or on a track
+	rows, err := db.Query(`SELECT `+vinylColumns+` FROM vinyls
+		WHERE status = 'active' AND (
+			id IN (SELECT vinyl_id FROM vinyl_artists WHERE artist_id = $1)
+			OR id IN (SELECT vinyl_id FROM track_artists WHERE artist_id = $1))
+		ORDER BY year ASC, id ASC`, artist.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve data"})
+		log.Println(err)
+		return
+	}
+	defer rows.Close()
+
+	vinyls := []Vinyl{}
+	for rows.Next() {
+		v, err := scanVinyl(rows)
+		if err != nil {
+			fmt.Printf("Error scanning data: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error scanning data"})
+			return
+		}
+		vinyls = append(vinyls, v)
+	}
+	if err := attachVinylDetails(db, vinyls); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve vinyl details"})
+		log.Println(err)
+		return
+	}
+
+	playRows, err := db.Query(`
+		SELECT p.id, p.vinyl_id, u.username, p.play_time
+		FROM play p
+		JOIN users u ON p.user_id = u.id
+		WHERE p.status = TRUE AND (
+			p.vinyl_id IN (SELECT vinyl_id FROM vinyl_artists WHERE artist_id = $1)
+			OR p.vinyl_id IN (SELECT vinyl_id FROM track_artists WHERE artist_id = $1))
+		ORDER BY p.play_time DESC, p.id DESC
+		LIMIT 100`, artist.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve play history"})
+		log.Println(err)
+		return
+	}
+	defer playRows.Close()
+
+	plays := []PlayHistory{}
+	for playRows.Next() {
+		var p PlayHistory
+		if err := playRows.Scan(&p.ID, &p.VinylID, &p.Username, &p.PlayTime); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error scanning data"})
+			log.Println(err)
+			return
+		}
+		plays = append(plays, p)
+	}
+
+	cachedJSON(c, gin.H{
+		"artist":       artist,
+		"vinyls":       vinyls,
+		"play_history": plays,
+	})
+}
+
+// CreateArtist adds an artist with optional aliases
+func CreateArtist(c *gin.Context) {
+	var req struct {
+		Name     string   `json:"name"`
+		SortName string   `json:"sort_name"`
+		Aliases  []string `json:"aliases"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Artist name cannot be empty"})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	if _, err := findArtistByName(db, req.Name); err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "An artist with this name or alias already exists"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow("INSERT INTO artists (name, sort_name) VALUES ($1, $2) RETURNING id", req.Name, strings.TrimSpace(req.SortName)).Scan(&id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert artist"})
+		log.Println(err)
+		return
+	}
+	for _, alias := range req.Aliases {
+		if err := addArtistAlias(tx, id, alias); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit artist"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Artist created successfully", "id": id})
+}
+
+// UpdateArtist renames an artist or changes its sort name
+func UpdateArtist(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name     string `json:"name"`
+		SortName string `json:"sort_name"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Artist name cannot be empty"})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	result, err := db.Exec("UPDATE artists SET name = $1, sort_name = $2 WHERE id = $3", req.Name, strings.TrimSpace(req.SortName), id)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			c.JSON(http.StatusConflict, gin.H{"error": "An artist with this name already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update artist"})
+		log.Println(err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Artist not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Artist updated successfully", "id": id})
+}
+
+// addArtistAlias registers an alternative spelling of an artist's name
+func addArtistAlias(db dbExecutor, artistID int, alias string) error {
+	alias = strings.TrimSpace(alias)
+	if alias == "" {
+		return fmt.Errorf("alias cannot be empty")
+	}
+	if existing, err := findArtistByName(db, alias); err == nil {
+		if existing == artistID {
+			return nil
+		}
+		return fmt.Errorf("alias %q already belongs to artist %d", alias, existing)
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+	_, err := db.Exec("INSERT INTO artist_aliases (artist_id, alias) VALUES ($1, $2)", artistID, alias)
+	return err
+}
+
+// AddArtistAlias adds an alias such as "Beatles" for "The Beatles"
+func AddArtistAlias(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Alias string `json:"alias"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	artistID, err := resolveArtist(db, ArtistCredit{ArtistID: id})
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Artist not found"})
+		return
+	}
+
+	if err := addArtistAlias(db, artistID, req.Alias); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Alias added successfully"})
+}
+
+// DeleteArtistAlias removes an alias from an artist
+func DeleteArtistAlias(c *gin.Context) {
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	result, err := db.Exec("DELETE FROM artist_aliases WHERE artist_id = $1 AND LOWER(alias) = LOWER($2)", c.Param("id"), c.Param("alias"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete alias"})
+		log.Println(err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alias not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Alias deleted successfully"})
+}
+
+// MergeArtists merges a duplicate artist (source_id) into the artist in the URL.
+// Links and aliases move to the target and the source name becomes an alias.
+func MergeArtists(c *gin.Context) {
+	var req struct {
+		SourceID int `json:"source_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.SourceID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing source_id"})
+		return
+	}
+	targetID, ok := paramID(c)
+	if !ok {
+		return
+	}
+	if targetID == req.SourceID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot merge an artist into itself"})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	var targetName, sourceName string
+	if err := tx.QueryRow("SELECT name FROM artists WHERE id = $1", targetID).Scan(&targetName); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Target artist not found"})
+		return
+	}
+	if err := tx.QueryRow("SELECT name FROM artists WHERE id = $1", req.SourceID).Scan(&sourceName); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Source artist not found"})
+		return
+	}
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`INSERT INTO vinyl_artists (vinyl_id, artist_id, role, position)
+			SELECT vinyl_id, $1, role, position FROM vinyl_artists WHERE artist_id = $2
+			ON CONFLICT DO NOTHING`, []interface{}{targetID, req.SourceID}},
+		{`DELETE FROM vinyl_artists WHERE artist_id = $1`, []interface{}{req.SourceID}},
+		{`INSERT INTO track_artists (vinyl_id, track_side, track_order, artist_id, role)
+			SELECT vinyl_id, track_side, track_order, $1, role FROM track_artists WHERE artist_id = $2
+			ON CONFLICT DO NOTHING`, []interface{}{targetID, req.SourceID}},
+		{`DELETE FROM track_artists WHERE artist_id = $1`, []interface{}{req.SourceID}},
+		{`UPDATE artist_aliases SET artist_id = $1 WHERE artist_id = $2`, []interface{}{targetID, req.SourceID}},
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement.query, statement.args...); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to merge artists"})
+			log.Println(err)
+			return
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM artists WHERE id = $1", req.SourceID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete merged artist"})
+		log.Println(err)
+		return
+	}
+	if !strings.EqualFold(sourceName, targetName) {
+		if err := addArtistAlias(tx, targetID, sourceName); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to keep merged name as alias"})
+			log.Println(err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit merge"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Artist %q merged into %q", sourceName, targetName), "id": targetID})
+}
+
+// LinkUnlinkedVinyls links vinyls added before artists were tracked, using
+// their free-text Artist field
+func LinkUnlinkedVinyls(c *gin.Context) {
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT id, artist FROM vinyls
+		WHERE artist <> '' AND id NOT IN (SELECT vinyl_id FROM vinyl_artists)`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve data"})
+		log.Println(err)
+		return
+	}
+	var pending []Vinyl
+	for rows.Next() {
+		var v Vinyl
+		if err := rows.Scan(&v.ID, &v.Artist); err != nil {
+			rows.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error scanning data"})
+			return
+		}
+		pending = append(pending, v)
+	}
+	rows.Close()
+
+	linked := 0
+	for _, v := range pending {
+		if err := setVinylArtists(db, v.ID, v); err != nil {
+			log.Println(err)
+			continue
+		}
+		linked++
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Vinyls linked to artists", "linked": linked})
+}
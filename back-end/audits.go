@@ -0,0 +1,612 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuditSession is a stocktake of the whole collection or of one location
+type AuditSession struct {
+	ID         int          `json:"id"`
+	Name       string       `json:"name"`
+	LocationID *int         `json:"location_id"` // scope of the audit, null for the whole collection
+	Username   string       `json:"username"`
+	StartedAt  string       `json:"started_at"`
+	ClosedAt   *string      `json:"closed_at"`
+	ScanCount  int          `json:"scan_count"`
+	Report     *AuditReport `json:"report,omitempty"`
+}
+
+// AuditScan records one record ticked as found during an audit
+type AuditScan struct {
+	ID         int    `json:"id"`
+	VinylID    *int   `json:"vinyl_id"` // null if the barcode matched no record
+	Barcode    string `json:"barcode"`
+	LocationID *int   `json:"location_id"` // where the record was found
+	ScannedAt  string `json:"scanned_at"`
+}
+
+// AuditItem is one line of an audit report
+type AuditItem struct {
+	VinylID            *int   `json:"vinyl_id"`
+	Title              string `json:"title"`
+	Artist             string `json:"artist"`
+	Barcode            string `json:"barcode,omitempty"`
+	ExpectedLocationID *int   `json:"expected_location_id"`
+	FoundLocationID    *int   `json:"found_location_id"`
+}
+
+// AuditReport compares the scans of a session with the active vinyls in its scope.
+// Missing records were expected but not scanned, misplaced records were found
+// outside their recorded location and unexpected scans match no active record.
+// Records lent out and not scanned are listed as on loan rather than missing.
+type AuditReport struct {
+	Expected   int         `json:"expected"`
+	Scanned    int         `json:"scanned"`
+	Found      []AuditItem `json:"found"`
+	Missing    []AuditItem `json:"missing"`
+	OnLoan     []AuditItem `json:"on_loan"`
+	Misplaced  []AuditItem `json:"misplaced"`
+	Unexpected []AuditItem `json:"unexpected"`
+}
+
+var errAuditClosed = fmt.Errorf("audit session is closed")
+
+// locationParents maps every location to its parent, for containment checks
+func locationParents(db dbExecutor) (map[int]int, error) {
+	rows, err := db.Query("SELECT id, parent_id FROM locations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	parents := map[int]int{}
+	for rows.Next() {
+		var id int
+		var parentID sql.NullInt64
+		if err := rows.Scan(&id, &parentID); err != nil {
+			return nil, err
+		}
+		if parentID.Valid {
+			parents[id] = int(parentID.Int64)
+		}
+	}
+	return parents, rows.Err()
+}
+
+// locationWithin reports whether location is container or nested inside it
+func locationWithin(parents map[int]int, location *int, container int) bool {
+	if location == nil {
+		return false
+	}
+	id := *location
+	for depth := 0; depth <= len(parents); depth++ {
+		if id == container {
+			return true
+		}
+		parent, ok := parents[id]
+		if !ok {
+			return false
+		}
+		id = parent
+	}
+	return false
+}
+
+// buildAuditReport computes the report of a session from its current scans
+func buildAuditReport(db dbExecutor, sessionID int, scope *int) (AuditReport, error) {
+	report := AuditReport{Found: []AuditItem{}, Missing: []AuditItem{}, OnLoan: []AuditItem{}, Misplaced: []AuditItem{}, Unexpected: []AuditItem{}}
+
+	parents, err := locationParents(db)
+	if err != nil {
+		return report, err
+	}
+
+	filter := newVinylFilter()
+	if scope != nil {
+		filter.add(fmt.Sprintf(locationSubtreeCondition, filter.arg(*scope)))
+	}
+	rows, err := db.Query("SELECT id, title, artist, location_id, "+onLoanCondition+" FROM vinyls"+filter.where()+" ORDER BY artist, title", filter.args...)
+	if err != nil {
+		return report, err
+	}
+	expected := []AuditItem{}
+	onLoan := map[int]bool{}
+	for rows.Next() {
+		var item AuditItem
+		var vinylID int
+		var location sql.NullInt64
+		var lent bool
+		if err := rows.Scan(&vinylID, &item.Title, &item.Artist, &location, &lent); err != nil {
+			rows.Close()
+			return report, err
+		}
+		item.VinylID = &vinylID
+		item.ExpectedLocationID = nullableID(location)
+		expected = append(expected, item)
+		onLoan[vinylID] = lent
+	}
+	rows.Close()
+	report.Expected = len(expected)
+
+	rows, err = db.Query(`SELECT s.vinyl_id, s.barcode, s.location_id,
+			COALESCE(v.title, ''), COALESCE(v.artist, ''), v.location_id, COALESCE(v.status = 'active', false)
+		FROM audit_scans s
+		LEFT JOIN vinyls v ON v.id = s.vinyl_id
+		WHERE s.session_id = $1
+		ORDER BY s.scanned_at, s.id`, sessionID)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+
+	scanned := map[int]bool{}
+	for rows.Next() {
+		var item AuditItem
+		var vinylID, found, recorded sql.NullInt64
+		var active bool
+		if err := rows.Scan(&vinylID, &item.Barcode, &found, &item.Title, &item.Artist, &recorded, &active); err != nil {
+			return report, err
+		}
+		item.VinylID = nullableID(vinylID)
+		item.FoundLocationID = nullableID(found)
+		item.ExpectedLocationID = nullableID(recorded)
+		report.Scanned++
+
+		switch {
+		case !active:
+			report.Unexpected = append(report.Unexpected, item)
+		case item.FoundLocationID != nil && !locationWithin(parents, item.ExpectedLocationID, *item.FoundLocationID):
+			// A record found on a shelf counts as in place if it is filed in a box on that shelf
+			report.Misplaced = append(report.Misplaced, item)
+			scanned[*item.VinylID] = true
+		default:
+			report.Found = append(report.Found, item)
+			scanned[*item.VinylID] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+
+	for _, item := range expected {
+		switch {
+		case scanned[*item.VinylID]:
+		case onLoan[*item.VinylID]:
+			report.OnLoan = append(report.OnLoan, item)
+		default:
+			report.Missing = append(report.Missing, item)
+		}
+	}
+	return report, nil
+}
+
+// loadAuditSession reads a session and, once closed, its stored report
+func loadAuditSession(db dbExecutor, id int) (AuditSession, error) {
+	var s AuditSession
+	var location sql.NullInt64
+	var closedAt sql.NullString
+	var reportJSON []byte
+	err := db.QueryRow(`SELECT a.id, a.name, a.location_id, COALESCE(u.username, ''), a.started_at, a.closed_at, a.report,
+			(SELECT COUNT(*) FROM audit_scans s WHERE s.session_id = a.id)
+		FROM audit_sessions a
+		LEFT JOIN users u ON a.user_id = u.id
+		WHERE a.id = $1`, id).Scan(&s.ID, &s.Name, &location, &s.Username, &s.StartedAt, &closedAt, &reportJSON, &s.ScanCount)
+	if err != nil {
+		return s, err
+	}
+	s.LocationID = nullableID(location)
+	if closedAt.Valid {
+		s.ClosedAt = &closedAt.String
+	}
+	if reportJSON != nil {
+		var report AuditReport
+		if err := json.Unmarshal(reportJSON, &report); err != nil {
+			return s, fmt.Errorf("decoding audit report: %w", err)
+		}
+		s.Report = &report
+	}
+	return s, nil
+}
+
+// GetAuditSessions lists audit sessions, newest first
+func GetAuditSessions(c *gin.Context) {
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT a.id, a.name, a.location_id, COALESCE(u.username, ''), a.started_at, a.closed_at,
+			(SELECT COUNT(*) FROM audit_scans s WHERE s.session_id = a.id)
+		FROM audit_sessions a
+		LEFT JOIN users u ON a.user_id = u.id
+		ORDER BY a.started_at DESC, a.id DESC`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve audit sessions"})
+		log.Println(err)
+		return
+	}
+	defer rows.Close()
+
+	sessions := []AuditSession{}
+	for rows.Next() {
+		var s AuditSession
+		var location sql.NullInt64
+		var closedAt sql.NullString
+		if err := rows.Scan(&s.ID, &s.Name, &location, &s.Username, &s.StartedAt, &closedAt, &s.ScanCount); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error scanning data"})
+			log.Println(err)
+			return
+		}
+		s.LocationID = nullableID(location)
+		if closedAt.Valid {
+			s.ClosedAt = &closedAt.String
+		}
+		sessions = append(sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error iterating rows"})
+		return
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// GetAuditSession returns a session with its scans. Open sessions include a
+// preview of the report as it would be if the session closed now.
+func GetAuditSession(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	session, err := loadAuditSession(db, id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Audit session not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve audit session"})
+		log.Println(err)
+		return
+	}
+
+	if session.Report == nil {
+		report, err := buildAuditReport(db, session.ID, session.LocationID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build audit report"})
+			log.Println(err)
+			return
+		}
+		session.Report = &report
+	}
+
+	rows, err := db.Query(`SELECT id, vinyl_id, barcode, location_id, scanned_at
+		FROM audit_scans WHERE session_id = $1 ORDER BY scanned_at, id`, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve scans"})
+		log.Println(err)
+		return
+	}
+	defer rows.Close()
+
+	scans := []AuditScan{}
+	for rows.Next() {
+		var s AuditScan
+		var vinylID, location sql.NullInt64
+		if err := rows.Scan(&s.ID, &vinylID, &s.Barcode, &location, &s.ScannedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error scanning data"})
+			log.Println(err)
+			return
+		}
+		s.VinylID = nullableID(vinylID)
+		s.LocationID = nullableID(location)
+		scans = append(scans, s)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"session": session, "scans": scans})
+}
+
+// StartAudit opens an audit session, optionally limited to one location
+func StartAudit(c *gin.Context) {
+	var req struct {
+		Name       string `json:"name"`
+		LocationID *int   `json:"location_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	if req.LocationID != nil {
+		var exists bool
+		if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM locations WHERE id = $1)", *req.LocationID).Scan(&exists); err != nil || !exists {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Location not found"})
+			return
+		}
+	}
+
+	var id int
+	err = db.QueryRow("INSERT INTO audit_sessions (name, location_id, user_id, started_at) VALUES ($1, $2, $3, NOW()) RETURNING id",
+		strings.TrimSpace(req.Name), req.LocationID, c.MustGet("user_id").(int)).Scan(&id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start audit"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Audit started", "id": id})
+}
+
+// openAuditScope returns the scope of an open session
+func openAuditScope(db dbExecutor, id int) (*int, error) {
+	var location sql.NullInt64
+	var closed bool
+	if err := db.QueryRow("SELECT location_id, closed_at IS NOT NULL FROM audit_sessions WHERE id = $1", id).Scan(&location, &closed); err != nil {
+		return nil, err
+	}
+	if closed {
+		return nil, errAuditClosed
+	}
+	return nullableID(location), nil
+}
+
+// ScanAuditRecord ticks a record as found, by vinyl_id or by barcode. The
+// location defaults to the session's location. Scanning a record twice keeps
+// the latest location.
+func ScanAuditRecord(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		VinylID    *int   `json:"vinyl_id"`
+		Barcode    string `json:"barcode"`
+		LocationID *int   `json:"location_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	req.Barcode = normalizeBarcode(req.Barcode)
+	if req.VinylID == nil && req.Barcode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing vinyl_id or barcode"})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	scope, err := openAuditScope(db, id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Audit session not found"})
+		return
+	}
+	if err == errAuditClosed {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve audit session"})
+		log.Println(err)
+		return
+	}
+	if req.LocationID == nil {
+		req.LocationID = scope
+	} else if err := validateLocationID(db, req.LocationID); err == errLocationNotFound {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Location not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check location"})
+		log.Println(err)
+		return
+	}
+
+	if req.VinylID == nil {
+		// Resolve the barcode to an active record; several copies need an explicit vinyl_id
+		rows, err := db.Query("SELECT id, title, artist FROM vinyls WHERE status = 'active' AND barcode = $1", req.Barcode)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up barcode"})
+			log.Println(err)
+			return
+		}
+
+		matches := []IdentifierWarning{}
+		for rows.Next() {
+			m := IdentifierWarning{Field: "barcode", Value: req.Barcode}
+			if err := rows.Scan(&m.VinylID, &m.Title, &m.Artist); err != nil {
+				rows.Close()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error scanning data"})
+				log.Println(err)
+				return
+			}
+			matches = append(matches, m)
+		}
+		rows.Close()
+		if len(matches) > 1 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Barcode matches several records, scan by vinyl_id", "matches": matches})
+			return
+		}
+		if len(matches) == 1 {
+			req.VinylID = &matches[0].VinylID
+		}
+	} else {
+		var exists bool
+		if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM vinyls WHERE id = $1)", *req.VinylID).Scan(&exists); err != nil || !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Vinyl not found"})
+			return
+		}
+	}
+
+	var scanID int
+	if req.VinylID != nil {
+		err = db.QueryRow(`INSERT INTO audit_scans (session_id, vinyl_id, barcode, location_id, user_id, scanned_at)
+			VALUES ($1, $2, $3, $4, $5, NOW())
+			ON CONFLICT (session_id, vinyl_id)
+			DO UPDATE SET location_id = EXCLUDED.location_id, scanned_at = EXCLUDED.scanned_at
+			RETURNING id`, id, *req.VinylID, req.Barcode, req.LocationID, c.MustGet("user_id").(int)).Scan(&scanID)
+	} else {
+		err = db.QueryRow(`INSERT INTO audit_scans (session_id, barcode, location_id, user_id, scanned_at)
+			VALUES ($1, $2, $3, $4, NOW()) RETURNING id`, id, req.Barcode, req.LocationID, c.MustGet("user_id").(int)).Scan(&scanID)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record scan"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Scan recorded", "id": scanID, "vinyl_id": req.VinylID, "location_id": req.LocationID})
+}
+
+// DeleteAuditScan unticks a scan of an open session
+func DeleteAuditScan(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+	scanID := c.Param("scan_id")
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	if _, err := openAuditScope(db, id); err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Audit session not found"})
+		} else if err == errAuditClosed {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve audit session"})
+			log.Println(err)
+		}
+		return
+	}
+
+	result, err := db.Exec("DELETE FROM audit_scans WHERE id = $1 AND session_id = $2", scanID, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete scan"})
+		log.Println(err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scan not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Scan deleted"})
+}
+
+// CloseAudit closes a session and stores its report. With apply_moves the
+// misplaced records are moved to where they were found.
+func CloseAudit(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		ApplyMoves bool `json:"apply_moves"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	scope, err := openAuditScope(tx, id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Audit session not found"})
+		return
+	}
+	if err == errAuditClosed {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve audit session"})
+		log.Println(err)
+		return
+	}
+
+	report, err := buildAuditReport(tx, id, scope)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build audit report"})
+		log.Println(err)
+		return
+	}
+
+	if req.ApplyMoves {
+		userID := c.MustGet("user_id").(int)
+		for _, item := range report.Misplaced {
+			if err := moveVinyl(tx, *item.VinylID, item.FoundLocationID, userID, fmt.Sprintf("Audit #%d", id)); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move misplaced records"})
+				log.Println(err)
+				return
+			}
+		}
+	}
+
+	reportJSON, err := json.Marshal(report)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode audit report"})
+		return
+	}
+	if _, err := tx.Exec("UPDATE audit_sessions SET closed_at = NOW(), report = $1 WHERE id = $2", reportJSON, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to close audit"})
+		log.Println(err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit audit"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Audit closed", "id": id, "moves_applied": req.ApplyMoves, "report": report})
+}
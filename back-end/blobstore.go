@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BlobStore stores the album art. Keys are slash-separated paths such as
+// "album/<hash>.jpg" or "album/trash/<name>"; the local store keeps them as
+// files under its root, so a local deployment keeps its ./album layout.
+type BlobStore interface {
+	// Put stores data under key, replacing any existing blob
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Get opens a blob. The reader implements io.ReadSeeker when the
+	// store can seek, which lets callers serve range requests.
+	Get(ctx context.Context, key string) (io.ReadCloser, BlobInfo, error)
+	Stat(ctx context.Context, key string) (BlobInfo, error)
+	// Delete removes a blob; deleting a missing blob is not an error
+	Delete(ctx context.Context, key string) error
+	// Rename moves a blob to a new key. The moved blob's ModTime is the
+	// time of the move, as with an S3 copy.
+	Rename(ctx context.Context, from, to string) error
+	// List calls fn for every blob whose key starts with prefix
+	List(ctx context.Context, prefix string, fn func(BlobInfo) error) error
+	// PresignGet returns a time-limited URL clients can read the blob from
+	// directly, or false when reads must be proxied through the backend
+	PresignGet(key string, expiry time.Duration) (string, bool)
+}
+
+// BlobInfo describes a stored blob
+type BlobInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+	ETag    string
+}
+
+var errBlobNotFound = errors.New("blob not found")
+
+// albumStore holds the album art, configured by STORAGE_BACKEND
+var albumStore BlobStore
+
+// loadBlobStore configures the album art store from the environment.
+// STORAGE_BACKEND is "local" (the default) or "s3".
+func loadBlobStore() (BlobStore, error) {
+	switch backend := strings.ToLower(os.Getenv("STORAGE_BACKEND")); backend {
+	case "", "local":
+		root := os.Getenv("LOCAL_STORAGE_DIR")
+		if root == "" {
+			root = "."
+		}
+		return &localBlobStore{root: root}, nil
+	case "s3":
+		return newS3BlobStore(s3Config{
+			Endpoint:      os.Getenv("S3_ENDPOINT"),
+			Region:        os.Getenv("S3_REGION"),
+			Bucket:        os.Getenv("S3_BUCKET"),
+			Prefix:        os.Getenv("S3_PREFIX"),
+			AccessKey:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretKey:     os.Getenv("S3_SECRET_ACCESS_KEY"),
+			PathStyle:     strings.ToLower(os.Getenv("S3_FORCE_PATH_STYLE")) != "false",
+			PresignReads:  strings.ToLower(os.Getenv("S3_PRESIGNED_READS")) == "true",
+			PresignExpiry: time.Duration(envInt("S3_PRESIGN_EXPIRY", 3600)) * time.Second,
+		})
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q, expected local or s3", backend)
+	}
+}
+
+// mustLoadBlobStore is loadBlobStore for start-up
+func mustLoadBlobStore() BlobStore {
+	store, err := loadBlobStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return store
+}
+
+// cleanBlobKey rejects keys that would escape the store
+func cleanBlobKey(key string) (string, error) {
+	cleaned := path.Clean("/" + key)[1:]
+	if cleaned == "" || cleaned != strings.TrimPrefix(key, "/") || strings.Contains(key, "\\") {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return cleaned, nil
+}
+
+// localBlobStore keeps blobs as files under root
+type localBlobStore struct {
+	root string
+}
+
+func (s *localBlobStore) path(key string) (string, error) {
+	key, err := cleanBlobKey(key)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+func (s *localBlobStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return err
+	}
+	// Write then rename, so that readers never see a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".put-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (s *localBlobStore) Get(ctx context.Context, key string) (io.ReadCloser, BlobInfo, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, BlobInfo{}, errBlobNotFound
+	}
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, BlobInfo{}, err
+	}
+	if fi.IsDir() {
+		f.Close()
+		return nil, BlobInfo{}, errBlobNotFound
+	}
+	return f, localBlobInfo(key, fi), nil
+}
+
+func (s *localBlobStore) Stat(ctx context.Context, key string) (BlobInfo, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	fi, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && fi.IsDir()) {
+		return BlobInfo{}, errBlobNotFound
+	}
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	return localBlobInfo(key, fi), nil
+}
+
+func (s *localBlobStore) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *localBlobStore) Rename(ctx context.Context, from, to string) error {
+	src, err := s.path(from)
+	if err != nil {
+		return err
+	}
+	dst, err := s.path(to)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	err = os.Rename(src, dst)
+	if errors.Is(err, fs.ErrNotExist) {
+		return errBlobNotFound
+	}
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	return os.Chtimes(dst, now, now)
+}
+
+func (s *localBlobStore) List(ctx context.Context, prefix string, fn func(BlobInfo) error) error {
+	// Walk the deepest directory the prefix names and filter the rest
+	dir := prefix[:max(strings.LastIndex(prefix, "/"), 0)]
+	start := s.root
+	if dir != "" {
+		var err error
+		if start, err = s.path(dir); err != nil {
+			return err
+		}
+	}
+	err := filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(localBlobInfo(key, fi))
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *localBlobStore) PresignGet(key string, expiry time.Duration) (string, bool) {
+	return "", false
+}
+
+func localBlobInfo(key string, fi fs.FileInfo) BlobInfo {
+	return BlobInfo{
+		Key:     key,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		ETag:    strconv.FormatInt(fi.ModTime().UnixNano(), 36) + "-" + strconv.FormatInt(fi.Size(), 36),
+	}
+}
+
+// serveBlob sends a stored blob, or redirects to it when the store hands out
+// presigned URLs. An empty etag falls back to the store's own validator.
+func serveBlob(c *gin.Context, key, etag, cacheControl string) {
+	if u, ok := albumStore.PresignGet(key, 0); ok {
+		c.Redirect(http.StatusFound, u)
+		return
+	}
+	ctx := c.Request.Context()
+	info, err := albumStore.Stat(ctx, key)
+	if err == errBlobNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+		log.Println(err)
+		return
+	}
+	if etag == "" && info.ETag != "" {
+		etag = `W/"` + info.ETag + `"`
+	}
+	if etag != "" {
+		c.Header("ETag", etag)
+	}
+	c.Header("Cache-Control", cacheControl)
+	c.Header("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	if notModified(c.Request, etag, info.ModTime) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	r, info, err := albumStore.Get(ctx, key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+		log.Println(err)
+		return
+	}
+	defer r.Close()
+
+	if rs, ok := r.(io.ReadSeeker); ok {
+		// Handles Range and If-Range and sniffs the content type
+		http.ServeContent(c.Writer, c.Request, path.Base(key), info.ModTime, rs)
+		return
+	}
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.DataFromReader(http.StatusOK, info.Size, contentType, r, nil)
+}
+
+// exportBlobs copies every blob under prefix into dir as files, keeping the
+// rest of their keys as paths
+func exportBlobs(ctx context.Context, store BlobStore, prefix, dir string) error {
+	return store.List(ctx, prefix, func(info BlobInfo) error {
+		dest := filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(info.Key, prefix)))
+		if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+			return err
+		}
+		r, _, err := store.Get(ctx, info.Key)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		f, err := os.Create(dest)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, r)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	})
+}
+
+// importBlobs stores every file under dir, keyed by prefix and its path
+func importBlobs(ctx context.Context, store BlobStore, dir, prefix string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return store.Put(ctx, prefix+filepath.ToSlash(rel), data, mime.TypeByExtension(path.Ext(p)))
+	})
+}
+
+// clearBlobs deletes every blob under prefix
+func clearBlobs(ctx context.Context, store BlobStore, prefix string) error {
+	// Collect first; deleting while listing can skip pages
+	var keys []string
+	if err := store.List(ctx, prefix, func(info BlobInfo) error {
+		keys = append(keys, info.Key)
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := store.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readBlob reads a whole blob into memory
+func readBlob(ctx context.Context, key string) ([]byte, error) {
+	r, _, err := albumStore.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestCleanBlobKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string // empty if the key is rejected
+	}{
+		{"album/cover.jpg", "album/cover.jpg"},
+		{"/album/cover.jpg", "album/cover.jpg"},
+		{"album/variants/400/cover.jpg.webp", "album/variants/400/cover.jpg.webp"},
+		{"", ""},
+		{"/", ""},
+		{"album/../../etc/passwd", ""},
+		{"../cover.jpg", ""},
+		{"album//cover.jpg", ""},
+		{"album/./cover.jpg", ""},
+		{"album/", ""},
+		{`album\..\cover.jpg`, ""},
+	}
+	for _, tt := range tests {
+		got, err := cleanBlobKey(tt.key)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("cleanBlobKey(%q) = %q, want an error", tt.key, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("cleanBlobKey(%q) = %q, %v, want %q", tt.key, got, err, tt.want)
+		}
+	}
+}
+
+// testBlobStore runs a store through put, get, stat, list, rename and delete
+func testBlobStore(t *testing.T, store BlobStore) {
+	t.Helper()
+	ctx := context.Background()
+
+	if _, err := store.Stat(ctx, "album/missing.jpg"); !errors.Is(err, errBlobNotFound) {
+		t.Fatalf("Stat of a missing blob: err = %v, want errBlobNotFound", err)
+	}
+	if _, _, err := store.Get(ctx, "album/missing.jpg"); !errors.Is(err, errBlobNotFound) {
+		t.Fatalf("Get of a missing blob: err = %v, want errBlobNotFound", err)
+	}
+
+	blobs := map[string]string{
+		"album/a b.jpg":             "first cover",
+		"album/variants/150/x.webp": "a variant",
+		"other/c.jpg":               "not a cover",
+	}
+	for key, data := range blobs {
+		if err := store.Put(ctx, key, []byte(data), "image/jpeg"); err != nil {
+			t.Fatalf("Put(%q): %v", key, err)
+		}
+	}
+	// Putting again replaces the blob
+	if err := store.Put(ctx, "album/a b.jpg", []byte("cover"), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+
+	r, info, err := store.Get(ctx, "album/a b.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || string(data) != "cover" || info.Size != 5 || info.Key != "album/a b.jpg" {
+		t.Errorf("Get = %q, %+v, %v", data, info, err)
+	}
+	stat, err := store.Stat(ctx, "album/a b.jpg")
+	if err != nil || stat.Size != 5 || stat.ETag == "" || stat.ModTime.IsZero() {
+		t.Errorf("Stat = %+v, %v", stat, err)
+	}
+
+	var listed []string
+	err = store.List(ctx, "album/", func(info BlobInfo) error {
+		listed = append(listed, info.Key)
+		return nil
+	})
+	sort.Strings(listed)
+	if want := []string{"album/a b.jpg", "album/variants/150/x.webp"}; err != nil || !reflect.DeepEqual(listed, want) {
+		t.Errorf("List(album/) = %q, %v, want %q", listed, err, want)
+	}
+
+	if err := store.Rename(ctx, "album/a b.jpg", "album/trash/a b.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Stat(ctx, "album/a b.jpg"); !errors.Is(err, errBlobNotFound) {
+		t.Errorf("Stat after rename: err = %v, want errBlobNotFound", err)
+	}
+	moved, err := store.Stat(ctx, "album/trash/a b.jpg")
+	if err != nil || moved.Size != 5 || time.Since(moved.ModTime) > time.Hour {
+		t.Errorf("Stat of the renamed blob = %+v, %v", moved, err)
+	}
+	if err := store.Rename(ctx, "album/missing.jpg", "album/trash/missing.jpg"); !errors.Is(err, errBlobNotFound) {
+		t.Errorf("Rename of a missing blob: err = %v, want errBlobNotFound", err)
+	}
+
+	for _, key := range []string{"album/trash/a b.jpg", "album/variants/150/x.webp", "other/c.jpg", "album/missing.jpg"} {
+		if err := store.Delete(ctx, key); err != nil {
+			t.Errorf("Delete(%q): %v", key, err)
+		}
+	}
+	listed = nil
+	store.List(ctx, "", func(info BlobInfo) error {
+		listed = append(listed, info.Key)
+		return nil
+	})
+	if len(listed) != 0 {
+		t.Errorf("blobs left after deleting all: %q", listed)
+	}
+
+	if err := store.Put(ctx, "../escape.jpg", []byte("x"), "image/jpeg"); err == nil {
+		t.Error("Put accepted a key outside the store")
+	}
+}
+
+func TestLocalBlobStore(t *testing.T) {
+	root := t.TempDir()
+	testBlobStore(t, &localBlobStore{root: root})
+
+	// Keys map onto the directory layout
+	store := &localBlobStore{root: root}
+	if err := store.Put(context.Background(), "album/cover.jpg", []byte("x"), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "album", "cover.jpg")); err != nil {
+		t.Error(err)
+	}
+}
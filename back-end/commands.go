@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+)
+
+// runCommand runs a maintenance command given on the command line instead
+// of starting the server, e.g.
+//
+//	go run . backfill-placeholders -all
+func runCommand(args []string) error {
+	switch args[0] {
+	case "backfill-placeholders":
+		return runBackfillPlaceholders(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// runBackfillPlaceholders backfills image placeholders batch by batch until
+// every image has been seen. -after resumes an interrupted run from the
+// last cursor it logged.
+func runBackfillPlaceholders(args []string) error {
+	flags := flag.NewFlagSet("backfill-placeholders", flag.ContinueOnError)
+	all := flags.Bool("all", false, "recompute every image, not only those missing placeholders")
+	after := flags.String("after", "", "resume after this image hash")
+	limit := flags.Int("limit", placeholderBatchSize, "images per batch")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *limit <= 0 {
+		return fmt.Errorf("limit must be a positive number")
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	cursor, updated, skipped := *after, 0, 0
+	for {
+		batch, err := backfillImagePlaceholders(ctx, db, cursor, *limit, *all)
+		if err != nil {
+			return fmt.Errorf("after %q: %w", cursor, err)
+		}
+		for _, s := range batch.Skipped {
+			log.Printf("skipped %s: %s", s["hash"], s["error"])
+		}
+		updated += batch.Updated
+		skipped += len(batch.Skipped)
+		if batch.Next == "" {
+			break
+		}
+		cursor = batch.Next
+		log.Printf("updated %d images, next batch after %s", updated, cursor)
+	}
+	log.Printf("done: updated %d images, skipped %d", updated, skipped)
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// goldmineGrades lists the Goldmine grades from best to worst
+var goldmineGrades = []string{"M", "NM", "VG+", "VG", "G", "P"}
+
+// ConditionChange is one entry of a vinyl's condition history
+type ConditionChange struct {
+	ID              int    `json:"id"`
+	VinylID         int    `json:"vinyl_id"`
+	MediaCondition  string `json:"media_condition"`
+	SleeveCondition string `json:"sleeve_condition"`
+	Note            string `json:"note"`
+	Username        string `json:"username"`
+	ChangedAt       string `json:"changed_at"`
+}
+
+// normalizeGrade canonicalises a grade such as "vg+" and reports whether it is
+// a Goldmine grade. The empty string (ungraded) is valid.
+func normalizeGrade(grade string) (string, bool) {
+	grade = strings.ToUpper(strings.TrimSpace(grade))
+	if grade == "" {
+		return "", true
+	}
+	for _, g := range goldmineGrades {
+		if grade == g {
+			return g, true
+		}
+	}
+	return grade, false
+}
+
+// gradesAtLeast returns the grades equal to or better than grade
+func gradesAtLeast(grade string) ([]string, bool) {
+	grade, ok := normalizeGrade(grade)
+	if !ok || grade == "" {
+		return nil, false
+	}
+	for i, g := range goldmineGrades {
+		if g == grade {
+			return goldmineGrades[:i+1], true
+		}
+	}
+	return nil, false
+}
+
+// validateGrades normalises the media and sleeve grades of a vinyl in place
+func validateGrades(media, sleeve *string) error {
+	var ok bool
+	if *media, ok = normalizeGrade(*media); !ok {
+		return fmt.Errorf("media_condition must be one of %s", strings.Join(goldmineGrades, ", "))
+	}
+	if *sleeve, ok = normalizeGrade(*sleeve); !ok {
+		return fmt.Errorf("sleeve_condition must be one of %s", strings.Join(goldmineGrades, ", "))
+	}
+	return nil
+}
+
+// recordConditionChange appends an entry to the condition history
+func recordConditionChange(db dbExecutor, vinylID interface{}, userID int, media, sleeve, note string) error {
+	_, err := db.Exec(`INSERT INTO condition_history (vinyl_id, user_id, media_condition, sleeve_condition, note, changed_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())`, vinylID, userID, media, sleeve, note)
+	return err
+}
+
+// UpdateVinylCondition regrades a vinyl, e.g. after cleaning or damage, and logs the change
+func UpdateVinylCondition(c *gin.Context) {
+	id := c.Param("id")
+
+	var req struct {
+		MediaCondition  string `json:"media_condition"`
+		SleeveCondition string `json:"sleeve_condition"`
+		Note            string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	if err := validateGrades(&req.MediaCondition, &req.SleeveCondition); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE vinyls SET media_condition = $1, sleeve_condition = $2 WHERE id = $3", req.MediaCondition, req.SleeveCondition, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update condition"})
+		log.Println(err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Vinyl not found"})
+		return
+	}
+
+	userID := c.MustGet("user_id").(int)
+	if err := recordConditionChange(tx, id, userID, req.MediaCondition, req.SleeveCondition, strings.TrimSpace(req.Note)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record condition history"})
+		log.Println(err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit condition change"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Condition updated successfully", "media_condition": req.MediaCondition, "sleeve_condition": req.SleeveCondition})
+}
+
+// GetConditionHistory lists the condition changes of a vinyl, newest first
+func GetConditionHistory(c *gin.Context) {
+	id := c.Param("id")
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`
+		SELECT h.id, h.vinyl_id, h.media_condition, h.sleeve_condition, h.note, COALESCE(u.username, ''), h.changed_at
+		FROM condition_history h
+		LEFT JOIN users u ON h.user_id = u.id
+		WHERE h.vinyl_id = $1
+		ORDER BY h.changed_at DESC, h.id DESC`, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve condition history"})
+		log.Println(err)
+		return
+	}
+	defer rows.Close()
+
+	history := []ConditionChange{}
+	for rows.Next() {
+		var h ConditionChange
+		if err := rows.Scan(&h.ID, &h.VinylID, &h.MediaCondition, &h.SleeveCondition, &h.Note, &h.Username, &h.ChangedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error scanning data"})
+			log.Println(err)
+			return
+		}
+		history = append(history, h)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error iterating rows"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"vinyl_id": id, "condition_history": history})
+}
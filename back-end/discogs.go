@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// discogsProvider implements MetadataProvider against the Discogs API
+type discogsProvider struct {
+	baseURL   string
+	token     string
+	userAgent string
+	client    *http.Client
+}
+
+type discogsSearchResponse struct {
+	Results []struct {
+		ID      int      `json:"id"`
+		Title   string   `json:"title"` // "Artist - Title"
+		Year    string   `json:"year"`
+		Country string   `json:"country"`
+		Label   []string `json:"label"`
+		Catno   string   `json:"catno"`
+		Barcode []string `json:"barcode"`
+		Format  []string `json:"format"`
+		Thumb   string   `json:"thumb"`
+	} `json:"results"`
+}
+
+type discogsArtist struct {
+	Name string `json:"name"`
+	ANV  string `json:"anv"` // artist name variation, as credited on this release
+	Join string `json:"join"`
+}
+
+type discogsRelease struct {
+	ID       int             `json:"id"`
+	Title    string          `json:"title"`
+	Year     int             `json:"year"`
+	Released string          `json:"released"` // "1969-09-26", may be partial
+	Country  string          `json:"country"`
+	Artists  []discogsArtist `json:"artists"`
+	Labels   []struct {
+		Name  string `json:"name"`
+		Catno string `json:"catno"`
+	} `json:"labels"`
+	Formats []struct {
+		Name         string   `json:"name"`
+		Qty          string   `json:"qty"`
+		Descriptions []string `json:"descriptions"`
+		Text         string   `json:"text"` // free text, usually the vinyl color
+	} `json:"formats"`
+	Identifiers []struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	} `json:"identifiers"`
+	Tracklist []struct {
+		Position string `json:"position"`
+		Type     string `json:"type_"`
+		Title    string `json:"title"`
+		Duration string `json:"duration"`
+	} `json:"tracklist"`
+}
+
+// discogsNameSuffix matches the " (2)" disambiguation Discogs appends to artist names
+var discogsNameSuffix = regexp.MustCompile(`\s+\(\d+\)$`)
+
+func (p *discogsProvider) Name() string {
+	return "discogs"
+}
+
+func (p *discogsProvider) header() http.Header {
+	header := http.Header{"User-Agent": {p.userAgent}}
+	if p.token != "" {
+		header.Set("Authorization", "Discogs token="+p.token)
+	}
+	return header
+}
+
+// formatDiscogsArtists joins Discogs release artists into a display string
+func formatDiscogsArtists(artists []discogsArtist) string {
+	var sb strings.Builder
+	for i, artist := range artists {
+		name := artist.ANV
+		if name == "" {
+			name = discogsNameSuffix.ReplaceAllString(artist.Name, "")
+		}
+		sb.WriteString(name)
+		if i < len(artists)-1 {
+			join := strings.TrimSpace(artist.Join)
+			if join == "" || join == "," {
+				sb.WriteString(join + " ")
+			} else {
+				sb.WriteString(" " + join + " ")
+			}
+		}
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+func (p *discogsProvider) Search(ctx context.Context, query MetadataQuery) ([]MetadataResult, error) {
+	params := url.Values{}
+	params.Set("type", "release")
+	params.Set("per_page", "25")
+	if query.Artist != "" {
+		params.Set("artist", query.Artist)
+	}
+	if query.Title != "" {
+		params.Set("release_title", query.Title)
+	}
+	if query.Barcode != "" {
+		params.Set("barcode", query.Barcode)
+	}
+
+	var response discogsSearchResponse
+	if err := fetchJSON(ctx, p.client, p.baseURL+"/database/search?"+params.Encode(), p.header(), &response); err != nil {
+		return nil, err
+	}
+
+	results := make([]MetadataResult, 0, len(response.Results))
+	for _, hit := range response.Results {
+		result := MetadataResult{
+			Provider:      p.Name(),
+			ID:            strconv.Itoa(hit.ID),
+			Title:         hit.Title,
+			Country:       hit.Country,
+			CatalogNumber: hit.Catno,
+			Format:        strings.Join(hit.Format, ", "),
+			ThumbURL:      hit.Thumb,
+		}
+		// search hits only carry a combined "Artist - Title"
+		if artist, title, found := strings.Cut(hit.Title, " - "); found {
+			result.Artist = discogsNameSuffix.ReplaceAllString(artist, "")
+			result.Title = title
+		}
+		result.Year, _ = strconv.Atoi(hit.Year)
+		if len(hit.Label) > 0 {
+			result.Label = hit.Label[0]
+		}
+		if len(hit.Barcode) > 0 {
+			result.Barcode = hit.Barcode[0]
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (p *discogsProvider) FetchRelease(ctx context.Context, id string) (Vinyl, error) {
+	var release discogsRelease
+	if err := fetchJSON(ctx, p.client, p.baseURL+"/releases/"+url.PathEscape(id), p.header(), &release); err != nil {
+		return Vinyl{}, err
+	}
+
+	vinyl := Vinyl{
+		Title:     strings.TrimSpace(release.Title),
+		Artist:    formatDiscogsArtists(release.Artists),
+		Year:      release.Year,
+		Country:   release.Country,
+		Tracklist: []Track{},
+	}
+
+	if _, err := time.Parse("2006-01-02", release.Released); err == nil {
+		vinyl.ReleaseDate = release.Released
+	}
+
+	if len(release.Labels) > 0 {
+		vinyl.Label = discogsNameSuffix.ReplaceAllString(release.Labels[0].Name, "")
+		vinyl.CatalogNumber = release.Labels[0].Catno
+	}
+	for _, identifier := range release.Identifiers {
+		if identifier.Type == "Barcode" {
+			vinyl.Barcode = normalizeBarcode(identifier.Value)
+			break
+		}
+	}
+
+	for _, format := range release.Formats {
+		if format.Name != "Vinyl" {
+			continue
+		}
+		qty, _ := strconv.Atoi(format.Qty)
+		vinyl.VinylNumber += qty
+		for _, description := range format.Descriptions {
+			if description == "LP" || description == "EP" {
+				vinyl.VinylType = description
+			}
+			if size := parseDiscSize(description); size != 0 {
+				vinyl.DiscSize = size
+			}
+			if rpm := parseRPM(description); rpm != 0 {
+				vinyl.RPM = rpm
+			}
+		}
+		if format.Text != "" {
+			vinyl.ColorVariant = format.Text
+		}
+	}
+
+	for i, track := range release.Tracklist {
+		// skip headings and index tracks
+		if track.Type != "" && track.Type != "track" {
+			continue
+		}
+		side, order, ok := splitTrackNumber(track.Position)
+		if !ok {
+			side = ""
+			order = i + 1
+		}
+		vinyl.Tracklist = append(vinyl.Tracklist, Track{
+			Side:   side,
+			Order:  order,
+			Title:  track.Title,
+			Length: track.Duration,
+		})
+	}
+
+	return vinyl, nil
+}
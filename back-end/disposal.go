@@ -0,0 +1,371 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// disposalStates are the statuses of records that left the collection. Unlike
+// 'deleted', which marks entries made by mistake, they stay visible with their
+// play history.
+var disposalStates = []string{"sold", "traded", "gifted", "lost", "broken"}
+
+func isDisposalState(status string) bool {
+	for _, s := range disposalStates {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Disposal describes how a record left the collection. SalePrice is what we
+// received in SaleCurrency, 0 unless sold or traded.
+type Disposal struct {
+	Status       string  `json:"status"`
+	DisposedAt   string  `json:"disposed_at"` // "2006-01-02"
+	SalePrice    float64 `json:"sale_price"`
+	SaleCurrency string  `json:"sale_currency"`
+	DisposedTo   string  `json:"disposed_to"`
+	Note         string  `json:"note"`
+}
+
+// DisposedVinyl is a record that left the collection
+type DisposedVinyl struct {
+	Vinyl
+	Disposal Disposal `json:"disposal"`
+}
+
+const disposalColumns = `status, COALESCE(TO_CHAR(disposed_at, 'YYYY-MM-DD'), ''), sale_price, sale_currency, disposed_to, disposal_note`
+
+// extraScanner appends destinations for columns selected after vinylColumns
+type extraScanner struct {
+	row   rowScanner
+	extra []interface{}
+}
+
+func (s extraScanner) Scan(dest ...interface{}) error {
+	return s.row.Scan(append(dest, s.extra...)...)
+}
+
+// scanDisposedVinyl scans a row selected with vinylColumns followed by disposalColumns
+func scanDisposedVinyl(row rowScanner) (DisposedVinyl, error) {
+	var d DisposedVinyl
+	var err error
+	d.Vinyl, err = scanVinyl(extraScanner{row, []interface{}{
+		&d.Disposal.Status, &d.Disposal.DisposedAt, &d.Disposal.SalePrice, &d.Disposal.SaleCurrency, &d.Disposal.DisposedTo, &d.Disposal.Note,
+	}})
+	return d, err
+}
+
+// DisposeVinyl takes a record out of the collection as sold, traded, gifted,
+// lost or broken. It keeps its picture and history and takes it off its shelf.
+// A sale price without sale_currency is taken to be in the vinyl's currency.
+func DisposeVinyl(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	var req Disposal
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	req.Status = strings.ToLower(strings.TrimSpace(req.Status))
+	if !isDisposalState(req.Status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be one of " + strings.Join(disposalStates, ", ")})
+		return
+	}
+	var err error
+	if req.DisposedAt, err = parseDateField("disposed_at", req.DisposedAt, true); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.SalePrice < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sale_price cannot be negative"})
+		return
+	}
+	if req.Status != "sold" && req.Status != "traded" {
+		req.SalePrice, req.SaleCurrency = 0, ""
+	}
+	req.SaleCurrency = strings.ToUpper(strings.TrimSpace(req.SaleCurrency))
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	var status, currency string
+	err = tx.QueryRow("SELECT status, UPPER(TRIM(COALESCE(currency, ''))) FROM vinyls WHERE id = $1 FOR UPDATE", id).Scan(&status, &currency)
+	if err == sql.ErrNoRows || (err == nil && status == "deleted") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Vinyl not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve data"})
+		log.Println(err)
+		return
+	}
+	if status != "active" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Vinyl already left the collection", "status": status})
+		return
+	}
+	// A sale price is in the purchase currency unless stated otherwise
+	if req.SalePrice > 0 && req.SaleCurrency == "" {
+		if currency == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "sale_currency is required when the vinyl has no currency"})
+			return
+		}
+		req.SaleCurrency = currency
+	}
+
+	onLoan, err := isOnLoan(tx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check loans"})
+		log.Println(err)
+		return
+	}
+	if onLoan {
+		c.JSON(http.StatusConflict, gin.H{"error": "Vinyl is on loan, record its return first"})
+		return
+	}
+
+	userID := c.MustGet("user_id").(int)
+	if err := moveVinyl(tx, id, nil, userID, "Left the collection: "+req.Status); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear location"})
+		log.Println(err)
+		return
+	}
+
+	_, err = tx.Exec(`UPDATE vinyls SET status = $1, disposed_at = $2::date, sale_price = $3, sale_currency = $4, disposed_to = $5, disposal_note = $6
+		WHERE id = $7`, req.Status, req.DisposedAt, req.SalePrice, req.SaleCurrency, strings.TrimSpace(req.DisposedTo), strings.TrimSpace(req.Note), id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update vinyl"})
+		log.Println(err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit disposal"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Vinyl marked as " + req.Status, "id": id})
+}
+
+// ReinstateVinyl brings a disposed record back into the collection, e.g.
+// when a sale falls through
+func ReinstateVinyl(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	result, err := db.Exec(`UPDATE vinyls SET status = 'active', disposed_at = NULL, sale_price = 0, sale_currency = '', disposed_to = '', disposal_note = ''
+		WHERE id = $1 AND status = ANY($2)`, id, pq.Array(disposalStates))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update vinyl"})
+		log.Println(err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No disposed vinyl with this id"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Vinyl reinstated successfully", "id": id})
+}
+
+// GetDisposedVinyls lists records that left the collection, most recent first,
+// optionally only those with ?status=
+func GetDisposedVinyls(c *gin.Context) {
+	states := disposalStates
+	if status := strings.ToLower(strings.TrimSpace(c.Query("status"))); status != "" {
+		if !isDisposalState(status) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status"})
+			return
+		}
+		states = []string{status}
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT "+vinylColumns+", "+disposalColumns+" FROM vinyls WHERE status = ANY($1) ORDER BY disposed_at DESC NULLS LAST, id DESC", pq.Array(states))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve data"})
+		log.Println(err)
+		return
+	}
+	defer rows.Close()
+
+	vinyls := []DisposedVinyl{}
+	for rows.Next() {
+		d, err := scanDisposedVinyl(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error scanning data"})
+			log.Println(err)
+			return
+		}
+		vinyls = append(vinyls, d)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error iterating rows"})
+		return
+	}
+
+	cachedJSON(c, vinyls)
+}
+
+// RealizedGain is the outcome of one disposed record: what it cost, price plus
+// shipping, against what we got for it. Converted amounts are null when a
+// currency has no exchange rate.
+type RealizedGain struct {
+	VinylID           int      `json:"vinyl_id"`
+	Title             string   `json:"title"`
+	Artist            string   `json:"artist"`
+	Status            string   `json:"status"`
+	DisposedAt        string   `json:"disposed_at"`
+	Cost              float64  `json:"cost"`
+	CostCurrency      string   `json:"cost_currency"`
+	Proceeds          float64  `json:"proceeds"`
+	ProceedsCurrency  string   `json:"proceeds_currency"`
+	CostConverted     *float64 `json:"cost_converted"`
+	ProceedsConverted *float64 `json:"proceeds_converted"`
+	GainLoss          *float64 `json:"gain_loss"`
+}
+
+// RealizedTotal sums the realized gains of one disposal status
+type RealizedTotal struct {
+	Status   string  `json:"status"`
+	Count    int     `json:"count"`
+	Cost     float64 `json:"cost"`
+	Proceeds float64 `json:"proceeds"`
+	GainLoss float64 `json:"gain_loss"`
+	Skipped  int     `json:"skipped"` // records left out for lack of an exchange rate
+}
+
+// GetRealizedGains reports the realized gain or loss of records that left the
+// collection, in one currency, per record and per disposal status. Records
+// given away, lost or broken count as a loss of their cost. ?year= limits the
+// report to one disposal year.
+func GetRealizedGains(c *gin.Context) {
+	rates, err := loadExchangeRates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load exchange rates"})
+		log.Println(err)
+		return
+	}
+	target, ok := valuationCurrency(c, rates)
+	if !ok {
+		return
+	}
+	year, hasYear, err := queryInt(c, "year")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT id, title, artist, status, COALESCE(TO_CHAR(disposed_at, 'YYYY-MM-DD'), ''),
+			COALESCE(price, 0) + shipping_cost, COALESCE(currency, ''), sale_price, sale_currency
+		FROM vinyls
+		WHERE status = ANY($1) AND ($2 = false OR EXTRACT(YEAR FROM disposed_at) = $3)
+		ORDER BY disposed_at DESC NULLS LAST, id DESC`, pq.Array(disposalStates), hasYear, year)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve data"})
+		log.Println(err)
+		return
+	}
+	defer rows.Close()
+
+	gains := []RealizedGain{}
+	byStatus := map[string]*RealizedTotal{}
+	total := RealizedTotal{Status: "total"}
+	for rows.Next() {
+		var g RealizedGain
+		if err := rows.Scan(&g.VinylID, &g.Title, &g.Artist, &g.Status, &g.DisposedAt, &g.Cost, &g.CostCurrency, &g.Proceeds, &g.ProceedsCurrency); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error scanning data"})
+			log.Println(err)
+			return
+		}
+		g.CostConverted = convertMoney(rates, g.Cost, g.CostCurrency, target)
+		if g.Proceeds == 0 {
+			zero := 0.0
+			g.ProceedsConverted = &zero
+		} else {
+			g.ProceedsConverted = convertMoney(rates, g.Proceeds, g.ProceedsCurrency, target)
+		}
+
+		t, ok := byStatus[g.Status]
+		if !ok {
+			t = &RealizedTotal{Status: g.Status}
+			byStatus[g.Status] = t
+		}
+		for _, t := range []*RealizedTotal{t, &total} {
+			t.Count++
+			if g.CostConverted == nil || g.ProceedsConverted == nil {
+				t.Skipped++
+				continue
+			}
+			t.Cost = roundMoney(t.Cost + *g.CostConverted)
+			t.Proceeds = roundMoney(t.Proceeds + *g.ProceedsConverted)
+			t.GainLoss = roundMoney(t.Proceeds - t.Cost)
+		}
+		if g.CostConverted != nil && g.ProceedsConverted != nil {
+			gain := roundMoney(*g.ProceedsConverted - *g.CostConverted)
+			g.GainLoss = &gain
+		}
+		gains = append(gains, g)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error iterating rows"})
+		return
+	}
+
+	statuses := make([]RealizedTotal, 0, len(byStatus))
+	for _, t := range byStatus {
+		statuses = append(statuses, *t)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Status < statuses[j].Status })
+
+	response := gin.H{"currency": target, "rates_date": rates.Date, "total": total, "by_status": statuses, "vinyls": gains}
+	if hasYear {
+		response["year"] = year
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// validRPMs and validDiscSizes list the accepted pressing values; 0 means unknown
+var (
+	validRPMs      = map[int]bool{0: true, 33: true, 45: true, 78: true}
+	validDiscSizes = map[int]bool{0: true, 7: true, 10: true, 12: true}
+)
+
+// validateVinyl checks the structured pressing fields of a submitted vinyl
+func validateVinyl(v *Vinyl) error {
+	if !validRPMs[v.RPM] {
+		return fmt.Errorf("rpm must be 33, 45 or 78")
+	}
+	if !validDiscSizes[v.DiscSize] {
+		return fmt.Errorf("disc_size must be 7, 10 or 12")
+	}
+	v.ReleaseDate = strings.TrimSpace(v.ReleaseDate)
+	if v.ReleaseDate != "" {
+		if _, err := time.Parse("2006-01-02", v.ReleaseDate); err != nil {
+			return fmt.Errorf("release_date must be formatted as YYYY-MM-DD")
+		}
+	}
+	// A number without a run size cannot be checked, so it is refused
+	if v.LimitedNumber < 0 || v.LimitedTotal < 0 || v.LimitedNumber > v.LimitedTotal {
+		return fmt.Errorf("limited_number must be between 1 and limited_total")
+	}
+	if err := validateGrades(&v.MediaCondition, &v.SleeveCondition); err != nil {
+		return err
+	}
+	return validateAcquisition(v)
+}
+
+// Errors returned by validateVinylReferences for an unknown location_id or seller_id
+var (
+	errLocationNotFound = errors.New("location not found")
+	errSellerNotFound   = errors.New("seller not found")
+)
+
+// validateVinylReferences checks that the location and seller a submitted vinyl refers to exist
+func validateVinylReferences(db dbExecutor, v *Vinyl) error {
+	if err := validateLocationID(db, v.LocationID); err != nil {
+		return err
+	}
+	return checkReference(db, "SELECT EXISTS (SELECT 1 FROM sellers WHERE id = $1)", v.SellerID, errSellerNotFound)
+}
+
+// validateLocationID checks that an optional location_id exists
+func validateLocationID(db dbExecutor, id *int) error {
+	return checkReference(db, "SELECT EXISTS (SELECT 1 FROM locations WHERE id = $1)", id, errLocationNotFound)
+}
+
+// checkReference runs an existence query for an optional id and returns
+// notFound if nothing matches
+func checkReference(db dbExecutor, query string, id *int, notFound error) error {
+	if id == nil {
+		return nil
+	}
+	var exists bool
+	if err := db.QueryRow(query, *id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return notFound
+	}
+	return nil
+}
+
+// vinylInputError returns the 400 message for an error caused by an id in a
+// submitted vinyl, and false for any other error
+func vinylInputError(err error) (string, bool) {
+	switch {
+	case errors.Is(err, errArtistNotFound):
+		return "Unknown artist_id in artists", true
+	case errors.Is(err, errLocationNotFound):
+		return "Location not found", true
+	case errors.Is(err, errSellerNotFound):
+		return "Seller not found", true
+	}
+	return "", false
+}
+
+// vinylFilter accumulates SQL conditions and their positional arguments
+type vinylFilter struct {
+	conditions []string
+	args       []interface{}
+}
+
+// newVinylFilter returns a filter matching the active collection
+func newVinylFilter() *vinylFilter {
+	return &vinylFilter{conditions: []string{"status = 'active'"}}
+}
+
+// arg registers a query argument and returns its $n placeholder
+func (f *vinylFilter) arg(value interface{}) string {
+	f.args = append(f.args, value)
+	return "$" + strconv.Itoa(len(f.args))
+}
+
+// add appends a condition built with placeholders from arg
+func (f *vinylFilter) add(condition string) {
+	f.conditions = append(f.conditions, condition)
+}
+
+// where renders the conditions as a WHERE clause
+func (f *vinylFilter) where() string {
+	return " WHERE " + strings.Join(f.conditions, " AND ")
+}
+
+// queryInt parses an optional integer query parameter
+func queryInt(c *gin.Context, name string) (int, bool, error) {
+	raw := strings.TrimSpace(c.Query(name))
+	if raw == "" {
+		return 0, false, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid %s", name)
+	}
+	return value, true, nil
+}
+
+// queryBool parses an optional boolean query parameter
+func queryBool(c *gin.Context, name string) (bool, bool, error) {
+	raw := strings.TrimSpace(c.Query(name))
+	if raw == "" {
+		return false, false, nil
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, false, fmt.Errorf("invalid %s", name)
+	}
+	return value, true, nil
+}
+
+// parseVinylFilter builds a filter from the list and search query parameters
+func parseVinylFilter(c *gin.Context) (*vinylFilter, error) {
+	f := newVinylFilter()
+
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		pattern := f.arg("%" + q + "%")
+		f.add(fmt.Sprintf("(title ILIKE %[1]s OR artist ILIKE %[1]s OR label ILIKE %[1]s OR catalog_number ILIKE %[1]s OR barcode ILIKE %[1]s OR description ILIKE %[1]s)", pattern))
+	}
+
+	// case-insensitive exact matches
+	for _, column := range []string{"artist", "label", "country", "vinyl_type", "acquisition_state"} {
+		if value := strings.TrimSpace(c.Query(column)); value != "" {
+			f.add(fmt.Sprintf("LOWER(%s) = LOWER(%s)", column, f.arg(value)))
+		}
+	}
+
+	// integer matches and ranges
+	intFilters := []struct {
+		param     string
+		condition string
+	}{
+		{"year", "year = %s"},
+		{"year_from", "year >= %s"},
+		{"year_to", "year <= %s"},
+		{"rpm", "rpm = %s"},
+		{"disc_size", "disc_size = %s"},
+		{"seller", "seller_id = %s"},
+	}
+	for _, filter := range intFilters {
+		value, ok, err := queryInt(c, filter.param)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			f.add(fmt.Sprintf(filter.condition, f.arg(value)))
+		}
+	}
+
+	dateFilters := []struct {
+		param     string
+		condition string
+	}{
+		{"release_date_from", "release_date >= %s::date"},
+		{"release_date_to", "release_date <= %s::date"},
+	}
+	for _, filter := range dateFilters {
+		raw := strings.TrimSpace(c.Query(filter.param))
+		if raw == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", raw); err != nil {
+			return nil, fmt.Errorf("invalid %s", filter.param)
+		}
+		f.add(fmt.Sprintf(filter.condition, f.arg(raw)))
+	}
+
+	// condition grades, exact or at least as good as the given grade
+	for _, column := range []string{"media_condition", "sleeve_condition"} {
+		if grade := c.Query(column); grade != "" {
+			normalized, ok := normalizeGrade(grade)
+			if !ok {
+				return nil, fmt.Errorf("invalid %s", column)
+			}
+			f.add(fmt.Sprintf("%s = %s", column, f.arg(normalized)))
+		}
+		if grade := c.Query("min_" + column); grade != "" {
+			grades, ok := gradesAtLeast(grade)
+			if !ok {
+				return nil, fmt.Errorf("invalid min_%s", column)
+			}
+			f.add(fmt.Sprintf("%s = ANY(%s)", column, f.arg(pq.Array(grades))))
+		}
+	}
+
+	// tags, each matching the tag or any of its descendants
+	for _, raw := range c.QueryArray("tag") {
+		tagID, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag")
+		}
+		f.add(fmt.Sprintf(tagSubtreeCondition, f.arg(tagID)))
+	}
+
+	// storage location, including everything stored inside it
+	locationID, ok, err := queryInt(c, "location")
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		f.add(fmt.Sprintf(locationSubtreeCondition, f.arg(locationID)))
+	}
+
+	// boolean flags, each with its true and false condition
+	boolFilters := []struct {
+		param     string
+		whenTrue  string
+		whenFalse string
+	}{
+		{"colored", "color_variant <> ''", "color_variant = ''"},
+		{"limited", "limited_total > 0", "limited_total = 0"},
+		{"located", "location_id IS NOT NULL", "location_id IS NULL"},
+		{"on_loan", onLoanCondition, "NOT " + onLoanCondition},
+		{"gift", "gift_from <> ''", "gift_from = ''"},
+	}
+	for _, filter := range boolFilters {
+		value, ok, err := queryBool(c, filter.param)
+		if err != nil {
+			return nil, err
+		}
+		if ok && value {
+			f.add(filter.whenTrue)
+		} else if ok {
+			f.add(filter.whenFalse)
+		}
+	}
+
+	return f, nil
+}
+
+// SearchVinyls searches the active collection by free text and the list filters
+func SearchVinyls(c *gin.Context) {
+	if strings.TrimSpace(c.Query("q")) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing search query q"})
+		return
+	}
+
+	filter, err := parseVinylFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT "+vinylColumns+" FROM vinyls"+filter.where()+" ORDER BY title ASC, id ASC", filter.args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve data"})
+		fmt.Println(err)
+		return
+	}
+	defer rows.Close()
+
+	vinyls := []Vinyl{}
+	for rows.Next() {
+		v, err := scanVinyl(rows)
+		if err != nil {
+			fmt.Printf("Error scanning data: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error scanning data"})
+			return
+		}
+		vinyls = append(vinyls, v)
+	}
+
+	if err := attachVinylDetails(db, vinyls); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve vinyl details"})
+		fmt.Println(err)
+		return
+	}
+
+	facets, err := countTagFacets(db, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count tags"})
+		fmt.Println(err)
+		return
+	}
+
+	cachedJSON(c, gin.H{"vinyls": vinyls, "total": len(vinyls), "facets": gin.H{"tags": facets}})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Hashed covers never change, so browsers and proxies may keep them for a
+// year without revalidating
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// etagMatches reports whether an If-None-Match header lists etag. GET uses
+// the weak comparison, so W/ prefixes are ignored.
+func etagMatches(header, etag string) bool {
+	if header == "" || etag == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified evaluates a conditional GET. If-None-Match takes precedence;
+// If-Modified-Since is only consulted without it, at one-second precision.
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, etag)
+	}
+	if modTime.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !modTime.Truncate(time.Second).After(since)
+}
+
+// cachedJSON writes v as JSON with an ETag of its content, answering 304
+// when the client already holds the same representation. Clients must
+// revalidate every time, so edits show up immediately.
+func cachedJSON(c *gin.Context, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode response"})
+		return
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache")
+	if notModified(c.Request, etag, time.Time{}) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+// albumCacheHeaders picks the validator and caching policy for a cover.
+// Hashed covers get a strong ETag derived from the content hash and may be
+// cached forever; legacy covers, whose file can be replaced under the same
+// name, use the store's validator and must be revalidated.
+func albumCacheHeaders(filename string, size int, format string) (etag, cacheControl string) {
+	m := hashedImageName.FindStringSubmatch(path.Base(filename))
+	if m == nil {
+		return "", "no-cache"
+	}
+	if size == 0 {
+		return `"` + m[1] + `"`, immutableCacheControl
+	}
+	return fmt.Sprintf(`"%s-%d-%s"`, m[1], size, format), immutableCacheControl
+}
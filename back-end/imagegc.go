@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Defaults for image garbage collection. Trash retention can be set with
+// TRASH_RETENTION_DAYS; the grace period protects covers that have been
+// uploaded but whose record has not been saved yet.
+const (
+	defaultTrashRetentionDays = 30
+	defaultOrphanGraceHours   = 24
+)
+
+// gcBlob is a stored file found by the garbage collector
+type gcBlob struct {
+	Key        string `json:"key"`
+	Size       int64  `json:"size"`
+	ModifiedAt string `json:"modified_at"`
+}
+
+// missingPicture is a record whose cover file is gone
+type missingPicture struct {
+	VinylID int    `json:"vinyl_id"`
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+}
+
+func newGCBlob(info BlobInfo) gcBlob {
+	return gcBlob{Key: info.Key, Size: info.Size, ModifiedAt: info.ModTime.UTC().Format(time.RFC3339)}
+}
+
+// referencedAlbumKeys returns the storage keys of every cover used by a
+// record that is not deleted or by a wishlist release not yet converted, and
+// those records' album_picture_url values
+func referencedAlbumKeys(db dbExecutor) (map[string]bool, []missingPicture, error) {
+	keys := map[string]bool{}
+	var pictures []missingPicture
+
+	rows, err := db.Query(`SELECT id, title, album_picture_url FROM vinyls
+		WHERE status <> 'deleted' AND album_picture_url LIKE '%/api/album/%' ORDER BY id`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p missingPicture
+		if err := rows.Scan(&p.VinylID, &p.Title, &p.URL); err != nil {
+			return nil, nil, err
+		}
+		if key, ok := albumPictureKey(p.URL); ok {
+			keys[key] = true
+			pictures = append(pictures, p)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	rows, err = db.Query(`SELECT DISTINCT i.hash, i.ext FROM vinyl_images vi
+		JOIN images i ON i.hash = vi.image_hash
+		JOIN vinyls v ON v.id = vi.vinyl_id
+		WHERE v.status <> 'deleted'`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hash, ext string
+		if err := rows.Scan(&hash, &ext); err != nil {
+			return nil, nil, err
+		}
+		keys[albumKey(imageFilename(hash, ext))] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	// Wishlist releases keep their cover until they are converted
+	rows, err = db.Query(`SELECT release->>'album_picture_url' FROM wishlist
+		WHERE converted_at IS NULL AND release->>'album_picture_url' LIKE '%/api/album/%'`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var pictureURL string
+		if err := rows.Scan(&pictureURL); err != nil {
+			return nil, nil, err
+		}
+		if key, ok := albumPictureKey(pictureURL); ok {
+			keys[key] = true
+		}
+	}
+	return keys, pictures, rows.Err()
+}
+
+// variantOriginal maps a variant key such as album/variants/400/x.png.webp
+// to the key of its original, album/x.png
+func variantOriginal(key string) (string, bool) {
+	rest := strings.TrimPrefix(key, albumVariantPrefix)
+	i := strings.Index(rest, "/")
+	format := strings.TrimPrefix(path.Ext(rest), ".")
+	if i < 0 || albumVariantFormats[format] == "" {
+		return "", false
+	}
+	name := strings.TrimSuffix(rest[i+1:], "."+format)
+	if name == "" || strings.Contains(name, "/") {
+		return "", false
+	}
+	return albumKey(name), true
+}
+
+// CollectOrphanedImages reports cover files that no record uses, trash older
+// than the retention period (?trash_days=, TRASH_RETENTION_DAYS, default 30),
+// variants whose original is gone and records whose cover file is missing.
+// GET only reports; POST also moves the orphans to the trash and deletes the
+// expired trash and stale variants. Files younger than ?min_age_hours=
+// (default 24) are never treated as orphans.
+func CollectOrphanedImages(c *gin.Context) {
+	remove := c.Request.Method == http.MethodPost
+	trashDays, ok, err := queryInt(c, "trash_days")
+	if err != nil || trashDays < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "trash_days must be a non-negative number"})
+		return
+	}
+	if !ok {
+		trashDays = envInt("TRASH_RETENTION_DAYS", defaultTrashRetentionDays)
+	}
+	minAgeHours, ok, err := queryInt(c, "min_age_hours")
+	if err != nil || minAgeHours < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "min_age_hours must be a non-negative number"})
+		return
+	}
+	if !ok {
+		minAgeHours = defaultOrphanGraceHours
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	referenced, pictures, err := referencedAlbumKeys(db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve data"})
+		log.Println(err)
+		return
+	}
+
+	ctx := c.Request.Context()
+	now := time.Now()
+	trashBefore := now.AddDate(0, 0, -trashDays)
+	orphanBefore := now.Add(-time.Duration(minAgeHours) * time.Hour)
+
+	stored := map[string]bool{}
+	orphans, expired, variants := []gcBlob{}, []gcBlob{}, []BlobInfo{}
+	err = albumStore.List(ctx, "album/", func(info BlobInfo) error {
+		switch {
+		case strings.HasPrefix(info.Key, "album/trash/"):
+			if info.ModTime.Before(trashBefore) {
+				expired = append(expired, newGCBlob(info))
+			}
+		case strings.HasPrefix(info.Key, albumVariantPrefix):
+			variants = append(variants, info)
+		case strings.Count(info.Key, "/") == 1:
+			stored[info.Key] = true
+			if !referenced[info.Key] && info.ModTime.Before(orphanBefore) {
+				orphans = append(orphans, newGCBlob(info))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list album files"})
+		log.Println(err)
+		return
+	}
+
+	orphaned := map[string]bool{}
+	for _, o := range orphans {
+		orphaned[o.Key] = true
+	}
+	stale := []gcBlob{}
+	for _, v := range variants {
+		// Variants of orphans go with them; ones without an original are stale
+		if original, ok := variantOriginal(v.Key); ok && stored[original] {
+			continue
+		}
+		stale = append(stale, newGCBlob(v))
+	}
+
+	missing := []missingPicture{}
+	for _, p := range pictures {
+		if key, _ := albumPictureKey(p.URL); !stored[key] {
+			missing = append(missing, p)
+		}
+	}
+
+	var reclaimable int64
+	for _, list := range [][]gcBlob{orphans, expired, stale} {
+		for _, b := range list {
+			reclaimable += b.Size
+		}
+	}
+
+	if remove {
+		if err := removeOrphanedImages(ctx, db, orphans, expired, stale); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove files"})
+			log.Println(err)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"removed":           remove,
+		"trash_days":        trashDays,
+		"orphans":           orphans,
+		"expired_trash":     expired,
+		"stale_variants":    stale,
+		"missing":           missing,
+		"reclaimable_bytes": reclaimable,
+	})
+}
+
+// removeOrphanedImages moves orphans to the trash, forgetting their images
+// rows once nothing links to them, and deletes expired trash and stale
+// variants
+func removeOrphanedImages(ctx context.Context, db dbExecutor, orphans, expired, stale []gcBlob) error {
+	for _, o := range orphans {
+		name := path.Base(o.Key)
+		if err := albumStore.Rename(ctx, o.Key, albumTrashKey(name)); err != nil && err != errBlobNotFound {
+			return fmt.Errorf("moving %s to the trash: %w", o.Key, err)
+		}
+		removeAlbumVariants(ctx, name)
+		if m := hashedImageName.FindStringSubmatch(name); m != nil {
+			if _, err := db.Exec("DELETE FROM images WHERE hash = $1 AND NOT EXISTS (SELECT 1 FROM vinyl_images WHERE image_hash = $1)", m[1]); err != nil {
+				return err
+			}
+		}
+	}
+	for _, list := range [][]gcBlob{expired, stale} {
+		for _, b := range list {
+			if err := albumStore.Delete(ctx, b.Key); err != nil {
+				return fmt.Errorf("deleting %s: %w", b.Key, err)
+			}
+		}
+	}
+	return nil
+}
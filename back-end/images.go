@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // register decoders for image.Decode
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/chai2010/webp"
+)
+
+// albumVariantSizes are the longest sides, in pixels, of the resized copies
+// kept for every cover, smallest first
+var albumVariantSizes = []int{150, 400, 1000}
+
+// albumVariantNames are the named sizes accepted by ServeAlbumPicture
+var albumVariantNames = map[string]int{"small": 150, "medium": 400, "large": 1000}
+
+const albumVariantPrefix = "album/variants/"
+
+// albumVariantSize maps a requested size to the smallest variant at least
+// that large. 0 means the original, also used for sizes beyond the largest
+// variant.
+func albumVariantSize(requested string) (int, error) {
+	requested = strings.ToLower(strings.TrimSpace(requested))
+	if requested == "" || requested == "original" {
+		return 0, nil
+	}
+	if size, ok := albumVariantNames[requested]; ok {
+		return size, nil
+	}
+	n, err := strconv.Atoi(requested)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("size must be a positive number of pixels, small, medium, large or original")
+	}
+	for _, size := range albumVariantSizes {
+		if n <= size {
+			return size, nil
+		}
+	}
+	return 0, nil
+}
+
+// albumVariantFormats are the formats variants are kept in, with their
+// content types
+var albumVariantFormats = map[string]string{"jpg": "image/jpeg", "webp": "image/webp"}
+
+// albumEagerVariants are the variants written when a cover is uploaded: the
+// JPEG sizes the web client shows. Other variants are made on first request.
+var albumEagerVariants = []int{150, 400}
+
+// albumVariantKey is where the variant of a cover is kept, e.g.
+// album/variants/400/cover.png.webp
+func albumVariantKey(filename string, size int, format string) string {
+	return albumVariantPrefix + strconv.Itoa(size) + "/" + path.Base(filename) + "." + format
+}
+
+// encodeAlbumVariant encodes a variant in the given format, "jpg" or "webp".
+// WebP variants are lossy, so they come out smaller than the JPEGs.
+func encodeAlbumVariant(w io.Writer, img image.Image, format string) error {
+	if format == "webp" {
+		return webp.Encode(w, img, &webp.Options{Quality: 80})
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: 82})
+}
+
+// writeAlbumVariant scales a cover and stores it in the given format
+func writeAlbumVariant(ctx context.Context, src image.Image, key string, size int, format string) error {
+	var buf bytes.Buffer
+	if err := encodeAlbumVariant(&buf, fitImage(src, size), format); err != nil {
+		return err
+	}
+	return albumStore.Put(ctx, key, buf.Bytes(), albumVariantFormats[format])
+}
+
+// generateAlbumVariants stores the variants of an uploaded cover that the
+// web client will ask for
+func generateAlbumVariants(ctx context.Context, filename string, src image.Image) error {
+	for _, size := range albumEagerVariants {
+		if err := writeAlbumVariant(ctx, src, albumVariantKey(filename, size, "jpg"), size, "jpg"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureAlbumVariant returns the key of a cover variant, generating it if
+// it is missing or older than the original
+func ensureAlbumVariant(ctx context.Context, filename string, size int, format string) (string, error) {
+	originalKey := albumKey(filename)
+	original, err := albumStore.Stat(ctx, originalKey)
+	if err != nil {
+		return "", err
+	}
+	key := albumVariantKey(filename, size, format)
+	if variant, err := albumStore.Stat(ctx, key); err == nil && !variant.ModTime.Before(original.ModTime) {
+		return key, nil
+	}
+	src, err := decodeAlbumImage(ctx, originalKey)
+	if err != nil {
+		return "", err
+	}
+	return key, writeAlbumVariant(ctx, src, key, size, format)
+}
+
+// removeAlbumVariants deletes the variants of a cover
+func removeAlbumVariants(ctx context.Context, filename string) {
+	for _, size := range albumVariantSizes {
+		for format := range albumVariantFormats {
+			if err := albumStore.Delete(ctx, albumVariantKey(filename, size, format)); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+// albumKey is the storage key of a cover file
+func albumKey(filename string) string {
+	return "album/" + path.Base(filename)
+}
+
+// albumTrashKey is where a removed cover file is kept
+func albumTrashKey(filename string) string {
+	return "album/trash/" + path.Base(filename)
+}
+
+// albumPictureKey maps an album_picture_url produced by UploadAlbumPicture
+// to its storage key. It reports false for URLs that point elsewhere.
+func albumPictureKey(pictureURL string) (string, bool) {
+	const marker = "/api/album/"
+	i := strings.LastIndex(pictureURL, marker)
+	if i < 0 {
+		return "", false
+	}
+	name, err := url.PathUnescape(pictureURL[i+len(marker):])
+	if err != nil || name == "" || path.Base(name) == "." || path.Base(name) == "/" {
+		return "", false
+	}
+	return albumKey(name), true
+}
+
+// decodeAlbumImage decodes a stored JPEG, PNG or GIF
+func decodeAlbumImage(ctx context.Context, key string) (image.Image, error) {
+	r, _, err := albumStore.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	img, _, err := image.Decode(r)
+	return img, err
+}
+
+// fitImage scales an image down so that its longest side is at most maxSide,
+// averaging the source pixels covered by each target pixel. Smaller images
+// are copied unscaled. The result is always opaque RGBA; transparent areas
+// are flattened onto white.
+func fitImage(src image.Image, maxSide int) *image.RGBA {
+	bounds := src.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+	dw, dh := sw, sh
+	if sw > maxSide || sh > maxSide {
+		if sw >= sh {
+			dw, dh = maxSide, max(1, sh*maxSide/sw)
+		} else {
+			dw, dh = max(1, sw*maxSide/sh), maxSide
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0 := bounds.Min.Y + y*sh/dh
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*sh/dh)
+		for x := 0; x < dw; x++ {
+			x0 := bounds.Min.X + x*sw/dw
+			x1 := max(x0+1, bounds.Min.X+(x+1)*sw/dw)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			// Premultiplied average, then composite over white
+			white := (n*0xffff - a)
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8((r + white) / n >> 8),
+				G: uint8((g + white) / n >> 8),
+				B: uint8((b + white) / n >> 8),
+				A: 0xff,
+			})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// testCover draws a smooth, photo-like picture
+func testCover(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: uint8(x * 255 / w), G: uint8(y * 255 / h), B: uint8((x + y) * 127 / (w + h)), A: 0xff})
+		}
+	}
+	return img
+}
+
+func TestEncodeAlbumVariant(t *testing.T) {
+	src := fitImage(testCover(800, 600), 400)
+	sizes := map[string]int{}
+	for format, decode := range map[string]func(*bytes.Reader) (image.Image, error){
+		"jpg":  func(r *bytes.Reader) (image.Image, error) { return jpeg.Decode(r) },
+		"webp": func(r *bytes.Reader) (image.Image, error) { return webp.Decode(r) },
+	} {
+		var buf bytes.Buffer
+		if err := encodeAlbumVariant(&buf, src, format); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		sizes[format] = buf.Len()
+
+		img, err := decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%s: decoding: %v", format, err)
+		}
+		if img.Bounds().Dx() != 400 || img.Bounds().Dy() != 300 {
+			t.Errorf("%s: bounds = %v, want 400x300", format, img.Bounds())
+		}
+		// Lossy, but close to the source away from the edges
+		for _, p := range []image.Point{{20, 20}, {200, 150}, {380, 280}, {100, 250}} {
+			want := src.RGBAAt(p.X, p.Y)
+			r, g, b, _ := img.At(p.X, p.Y).RGBA()
+			for i, pair := range [][2]int{{int(r >> 8), int(want.R)}, {int(g >> 8), int(want.G)}, {int(b >> 8), int(want.B)}} {
+				if d := pair[0] - pair[1]; d < -24 || d > 24 {
+					t.Errorf("%s: pixel %v channel %d = %d, want about %d", format, p, i, pair[0], pair[1])
+				}
+			}
+		}
+	}
+	if sizes["webp"] >= sizes["jpg"] {
+		t.Errorf("webp variant is %d bytes, jpeg %d; want webp smaller", sizes["webp"], sizes["jpg"])
+	}
+}
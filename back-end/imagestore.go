@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Covers are stored as album/<sha256 of the content><ext>, so a URL never
+// changes when the record's metadata does and identical uploads share a file.
+// The images table describes each file; vinyl_images maps records to them.
+
+// StoredImage is a cover file stored under the hash of its content
+type StoredImage struct {
+	Hash      string   `json:"hash"`
+	Ext       string   `json:"ext"`
+	Width     int      `json:"width"`
+	Height    int      `json:"height"`
+	SizeBytes int      `json:"size_bytes"`
+	Palette   []string `json:"palette"`  // dominant colours, most common first
+	Blurhash  string   `json:"blurhash"` // placeholder shown while the image loads
+	PHash     uint64   `json:"-"`        // perceptual hash, for finding look-alike covers
+	CreatedAt string   `json:"created_at"`
+}
+
+var hashedImageName = regexp.MustCompile(`^([0-9a-f]{64})(\.[a-z]+)$`)
+
+// isImageHash reports whether s looks like the hash of a stored image
+func isImageHash(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil && len(s) == 64 && strings.ToLower(s) == s
+}
+
+// imageFilename is the name a stored image is kept under in the album store
+func imageFilename(hash, ext string) string {
+	return hash + ext
+}
+
+// albumImageURL is the public URL of a stored image
+func albumImageURL(hash, ext string) string {
+	return fmt.Sprintf("%s/api/album/%s", os.Getenv("ALBUM_BASE_URL"), imageFilename(hash, ext))
+}
+
+// albumImageHash extracts the content hash from a cover URL; legacy,
+// title-based URLs report false
+func albumImageHash(pictureURL string) (string, bool) {
+	key, ok := albumPictureKey(pictureURL)
+	if !ok {
+		return "", false
+	}
+	m := hashedImageName.FindStringSubmatch(path.Base(key))
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// storeImage saves encoded image data under its hash unless an identical
+// file is already stored, and records it in the images table. It reports
+// whether the image was already there.
+func storeImage(ctx context.Context, db dbExecutor, data []byte, ext string, img image.Image) (StoredImage, bool, error) {
+	sum := sha256.Sum256(data)
+	stored := StoredImage{
+		Hash:      hex.EncodeToString(sum[:]),
+		Ext:       ext,
+		Width:     img.Bounds().Dx(),
+		Height:    img.Bounds().Dy(),
+		SizeBytes: len(data),
+	}
+	stored.Palette, stored.Blurhash = imagePlaceholders(img)
+	stored.PHash = perceptualHash(img)
+
+	key := albumKey(imageFilename(stored.Hash, ext))
+	_, err := albumStore.Stat(ctx, key)
+	if err != nil && err != errBlobNotFound {
+		return stored, false, err
+	}
+	existed := err == nil
+	if !existed {
+		if err := albumStore.Put(ctx, key, data, mime.TypeByExtension(ext)); err != nil {
+			return stored, false, err
+		}
+	}
+
+	err = db.QueryRow(`INSERT INTO images (hash, ext, width, height, size_bytes, palette, blurhash, phash, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW())
+		ON CONFLICT (hash) DO UPDATE SET palette = EXCLUDED.palette, blurhash = EXCLUDED.blurhash, phash = EXCLUDED.phash
+		RETURNING TO_CHAR(created_at, 'YYYY-MM-DD"T"HH24:MI:SSOF')`,
+		stored.Hash, stored.Ext, stored.Width, stored.Height, stored.SizeBytes, pq.Array(stored.Palette), stored.Blurhash, int64(stored.PHash)).Scan(&stored.CreatedAt)
+	return stored, existed, err
+}
+
+// linkVinylImage makes the image a vinyl's album_picture_url refers to its
+// primary image, attaching it as the front cover if needed. URLs outside the
+// image store leave the vinyl without a primary image.
+func linkVinylImage(db dbExecutor, vinylID interface{}, pictureURL string) error {
+	hash, ok := albumImageHash(pictureURL)
+	if _, err := db.Exec("UPDATE vinyl_images SET is_primary = false WHERE vinyl_id = $1 AND is_primary AND image_hash <> $2", vinylID, hash); err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	_, err := db.Exec(`INSERT INTO vinyl_images (vinyl_id, image_hash, kind, position, is_primary, added_at)
+		SELECT $1, hash, 'front', (SELECT COALESCE(MAX(position) + 1, 0) FROM vinyl_images WHERE vinyl_id = $1), true, NOW()
+		FROM images WHERE hash = $2
+		ON CONFLICT (vinyl_id, image_hash) DO UPDATE SET is_primary = true`, vinylID, hash)
+	return err
+}
+
+// pictureInUse reports whether a cover URL is used by a record other than
+// vinylID that is not deleted, or by a wishlist release not yet converted
+func pictureInUse(db dbExecutor, pictureURL string, vinylID interface{}) (bool, error) {
+	hash, _ := albumImageHash(pictureURL)
+	var inUse bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM vinyls WHERE id <> $2 AND status <> 'deleted'
+		AND (album_picture_url = $1 OR id IN (SELECT vinyl_id FROM vinyl_images WHERE image_hash = $3)))
+		OR EXISTS (SELECT 1 FROM wishlist WHERE converted_at IS NULL AND release->>'album_picture_url' = $1)`,
+		pictureURL, vinylID, hash).Scan(&inUse)
+	return inUse, err
+}
+
+// MigrateAlbumImages moves covers with legacy, title-based filenames, used by
+// records or by wishlist releases not yet bought, into the content-addressed
+// store and rewrites the URLs of the records and wishlist releases that use
+// them. Covers whose files are missing or cannot be decoded are listed and
+// left unchanged.
+func MigrateAlbumImages(c *gin.Context) {
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+	ctx := c.Request.Context()
+
+	rows, err := db.Query("SELECT id, album_picture_url FROM vinyls WHERE album_picture_url LIKE '%/api/album/%' AND status <> 'deleted' ORDER BY id")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve data"})
+		log.Println(err)
+		return
+	}
+	type cover struct {
+		id  int
+		url string
+	}
+	var covers []cover
+	for rows.Next() {
+		var cv cover
+		if err := rows.Scan(&cv.id, &cv.url); err != nil {
+			rows.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error scanning data"})
+			log.Println(err)
+			return
+		}
+		covers = append(covers, cv)
+	}
+	rows.Close()
+
+	migrated, skipped := 0, []gin.H{}
+	legacy := map[string]string{}
+	for _, cv := range covers {
+		if _, ok := albumImageHash(cv.url); ok {
+			if err := linkVinylImage(db, cv.id, cv.url); err != nil {
+				log.Println(err)
+			}
+			continue
+		}
+		key, _ := albumPictureKey(cv.url)
+		newURL, problem, err := storeLegacyCover(ctx, db, key)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store image"})
+			log.Println(err)
+			return
+		}
+		if problem != "" {
+			skipped = append(skipped, gin.H{"vinyl_id": cv.id, "url": cv.url, "error": problem})
+			continue
+		}
+		if _, err := db.Exec("UPDATE vinyls SET album_picture_url = $1 WHERE id = $2", newURL, cv.id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update vinyl"})
+			log.Println(err)
+			return
+		}
+		if err := linkVinylImage(db, cv.id, newURL); err != nil {
+			log.Println(err)
+		}
+		legacy[key] = newURL
+		migrated++
+	}
+
+	// Covers only a wishlist release still waiting to be bought uses
+	rows, err = db.Query(`SELECT id, release->>'album_picture_url' FROM wishlist
+		WHERE converted_at IS NULL AND release->>'album_picture_url' LIKE '%/api/album/%' ORDER BY id`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve wishlist"})
+		log.Println(err)
+		return
+	}
+	var wished []cover
+	for rows.Next() {
+		var cv cover
+		if err := rows.Scan(&cv.id, &cv.url); err != nil {
+			rows.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error scanning data"})
+			log.Println(err)
+			return
+		}
+		wished = append(wished, cv)
+	}
+	rows.Close()
+	for _, cv := range wished {
+		key, ok := albumPictureKey(cv.url)
+		if _, hashed := albumImageHash(cv.url); !ok || hashed || legacy[key] != "" {
+			continue
+		}
+		newURL, problem, err := storeLegacyCover(ctx, db, key)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store image"})
+			log.Println(err)
+			return
+		}
+		if problem != "" {
+			skipped = append(skipped, gin.H{"wishlist_id": cv.id, "url": cv.url, "error": problem})
+			continue
+		}
+		legacy[key] = newURL
+		migrated++
+	}
+
+	if err := rewriteWishlistPictures(db, legacy); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update wishlist"})
+		log.Println(err)
+		return
+	}
+
+	// Legacy files now have a hashed copy; put them in the trash
+	for key := range legacy {
+		if err := albumStore.Rename(ctx, key, albumTrashKey(key)); err != nil {
+			log.Println(err)
+		}
+		removeAlbumVariants(ctx, path.Base(key))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"migrated": migrated, "skipped": skipped})
+}
+
+// storeLegacyCover copies a title-named cover file into the content-addressed
+// store and returns the URL of the copy. problem explains why a file that is
+// missing or not an image was left alone; err is any other failure.
+func storeLegacyCover(ctx context.Context, db dbExecutor, key string) (newURL, problem string, err error) {
+	data, err := readBlob(ctx, key)
+	if err != nil {
+		return "", err.Error(), nil
+	}
+	// The file is kept byte for byte; only uploads are re-encoded
+	ext, ok := map[string]string{"jpeg": ".jpg", "png": ".png", "gif": ".gif"}[sniffImageFormat(data)]
+	if !ok {
+		return "", "unsupported file type", nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", err.Error(), nil
+	}
+	stored, _, err := storeImage(ctx, db, data, ext, img)
+	if err != nil {
+		return "", "", err
+	}
+	return albumImageURL(stored.Hash, stored.Ext), "", nil
+}
+
+// rewriteWishlistPictures points wishlist releases whose cover is one of the
+// migrated legacy files, keyed by storage key, at its hashed copy
+func rewriteWishlistPictures(db dbExecutor, migrated map[string]string) error {
+	if len(migrated) == 0 {
+		return nil
+	}
+	rows, err := db.Query(`SELECT id, release->>'album_picture_url' FROM wishlist
+		WHERE release->>'album_picture_url' LIKE '%/api/album/%'`)
+	if err != nil {
+		return err
+	}
+	updates := map[int]string{}
+	for rows.Next() {
+		var id int
+		var pictureURL string
+		if err := rows.Scan(&id, &pictureURL); err != nil {
+			rows.Close()
+			return err
+		}
+		if key, ok := albumPictureKey(pictureURL); ok && migrated[key] != "" {
+			updates[id] = migrated[key]
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, newURL := range updates {
+		if _, err := db.Exec(`UPDATE wishlist SET release = jsonb_set(release, '{album_picture_url}', to_jsonb($1::text)) WHERE id = $2`,
+			newURL, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// onLoanCondition is true for a vinyl with an open loan. It refers to the
+// vinyls table unaliased, like the rest of vinylColumns.
+const onLoanCondition = `EXISTS (SELECT 1 FROM loans WHERE loans.vinyl_id = vinyls.id AND loans.returned_at IS NULL)`
+
+// Loan is a record lent to someone. Dates are "2006-01-02"; DueAt and
+// ReturnedAt are empty when not set.
+type Loan struct {
+	ID              int    `json:"id"`
+	VinylID         int    `json:"vinyl_id"`
+	Title           string `json:"title"`
+	Artist          string `json:"artist"`
+	BorrowerName    string `json:"borrower_name"`
+	BorrowerContact string `json:"borrower_contact"`
+	LentAt          string `json:"lent_at"`
+	DueAt           string `json:"due_at"`
+	ReturnedAt      string `json:"returned_at"`
+	Note            string `json:"note"`
+	DaysOverdue     int    `json:"days_overdue"`
+}
+
+const loanColumns = `l.id, l.vinyl_id, v.title, v.artist, l.borrower_name, l.borrower_contact,
+	TO_CHAR(l.lent_at, 'YYYY-MM-DD'), COALESCE(TO_CHAR(l.due_at, 'YYYY-MM-DD'), ''), COALESCE(TO_CHAR(l.returned_at, 'YYYY-MM-DD'), ''), l.note,
+	CASE WHEN l.returned_at IS NULL AND l.due_at < CURRENT_DATE THEN CURRENT_DATE - l.due_at ELSE 0 END`
+
+// queryLoans runs a query selecting loanColumns from loans l joined with vinyls v
+func queryLoans(db dbExecutor, where string, args ...interface{}) ([]Loan, error) {
+	rows, err := db.Query(`SELECT `+loanColumns+` FROM loans l JOIN vinyls v ON v.id = l.vinyl_id `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	loans := []Loan{}
+	for rows.Next() {
+		var l Loan
+		if err := rows.Scan(&l.ID, &l.VinylID, &l.Title, &l.Artist, &l.BorrowerName, &l.BorrowerContact, &l.LentAt, &l.DueAt, &l.ReturnedAt, &l.Note, &l.DaysOverdue); err != nil {
+			return nil, err
+		}
+		loans = append(loans, l)
+	}
+	return loans, rows.Err()
+}
+
+// isOnLoan reports whether a vinyl has an open loan
+func isOnLoan(db dbExecutor, vinylID interface{}) (bool, error) {
+	var onLoan bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM loans WHERE vinyl_id = $1 AND returned_at IS NULL)", vinylID).Scan(&onLoan)
+	return onLoan, err
+}
+
+// parseDateField validates an optional date, falling back to today when empty and required
+func parseDateField(field, value string, required bool) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		if required {
+			return time.Now().Format("2006-01-02"), nil
+		}
+		return "", nil
+	}
+	if _, err := time.Parse("2006-01-02", value); err != nil {
+		return "", fmt.Errorf("%s must be a date formatted as YYYY-MM-DD", field)
+	}
+	return value, nil
+}
+
+// LendVinyl opens a loan on a vinyl that is not already lent out
+func LendVinyl(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		BorrowerName    string `json:"borrower_name"`
+		BorrowerContact string `json:"borrower_contact"`
+		LentAt          string `json:"lent_at"`
+		DueAt           string `json:"due_at"`
+		Note            string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	req.BorrowerName = strings.TrimSpace(req.BorrowerName)
+	if req.BorrowerName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Borrower name cannot be empty"})
+		return
+	}
+	var err error
+	if req.LentAt, err = parseDateField("lent_at", req.LentAt, true); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.DueAt, err = parseDateField("due_at", req.DueAt, false); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Both dates are YYYY-MM-DD so they compare as strings
+	if req.DueAt != "" && req.DueAt < req.LentAt {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "due_at cannot be before lent_at"})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRow("SELECT status FROM vinyls WHERE id = $1 FOR UPDATE", id).Scan(&status)
+	if err == sql.ErrNoRows || (err == nil && status != "active") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Vinyl not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve data"})
+		log.Println(err)
+		return
+	}
+
+	onLoan, err := isOnLoan(tx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check loans"})
+		log.Println(err)
+		return
+	}
+	if onLoan {
+		c.JSON(http.StatusConflict, gin.H{"error": "Vinyl is already on loan"})
+		return
+	}
+
+	var loanID int
+	err = tx.QueryRow(`INSERT INTO loans (vinyl_id, borrower_name, borrower_contact, lent_at, due_at, note, user_id)
+		VALUES ($1, $2, $3, $4::date, NULLIF($5, '')::date, $6, $7) RETURNING id`,
+		id, req.BorrowerName, strings.TrimSpace(req.BorrowerContact), req.LentAt, req.DueAt, strings.TrimSpace(req.Note), c.MustGet("user_id").(int)).Scan(&loanID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record loan"})
+		log.Println(err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit loan"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Vinyl lent successfully", "id": loanID})
+}
+
+// ReturnVinyl closes the open loan of a vinyl
+func ReturnVinyl(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		ReturnedAt string `json:"returned_at"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+	}
+	returnedAt, err := parseDateField("returned_at", req.ReturnedAt, true)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	var loanID int
+	err = db.QueryRow(`UPDATE loans SET returned_at = GREATEST($1::date, lent_at)
+		WHERE vinyl_id = $2 AND returned_at IS NULL RETURNING id`, returnedAt, id).Scan(&loanID)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{"error": "Vinyl is not on loan"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record return"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Vinyl returned successfully", "id": loanID})
+}
+
+// GetLoans lists loans, newest first. With active=true only open loans are listed.
+func GetLoans(c *gin.Context) {
+	active, _, err := queryBool(c, "active")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	where := "ORDER BY l.lent_at DESC, l.id DESC"
+	if active {
+		where = "WHERE l.returned_at IS NULL " + where
+	}
+	loans, err := queryLoans(db, where)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve loans"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, loans)
+}
+
+// GetOverdueLoans lists open loans past their due date, most overdue first
+func GetOverdueLoans(c *gin.Context) {
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	loans, err := queryLoans(db, "WHERE l.returned_at IS NULL AND l.due_at < CURRENT_DATE ORDER BY l.due_at ASC, l.id ASC")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve loans"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, loans)
+}
+
+// GetLoanHistory lists every loan of a vinyl, newest first
+func GetLoanHistory(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	loans, err := queryLoans(db, "WHERE l.vinyl_id = $1 ORDER BY l.lent_at DESC, l.id DESC", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve loans"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"vinyl_id": id, "loans": loans})
+}
@@ -0,0 +1,526 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// locationKinds ranks location kinds from outermost to innermost. A location
+// can only be placed inside a location of a lower rank.
+var locationKinds = map[string]int{"room": 0, "shelf": 1, "box": 2, "slot": 3}
+
+type Location struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Kind        string `json:"kind"`
+	ParentID    *int   `json:"parent_id"`
+	Description string `json:"description"`
+	VinylCount  int    `json:"vinyl_count"`
+}
+
+// LocationMove is one entry of a vinyl's location history
+type LocationMove struct {
+	ID             int    `json:"id"`
+	VinylID        int    `json:"vinyl_id"`
+	FromLocationID *int   `json:"from_location_id"`
+	ToLocationID   *int   `json:"to_location_id"`
+	Note           string `json:"note"`
+	Username       string `json:"username"`
+	MovedAt        string `json:"moved_at"`
+}
+
+// locationSubtreeCondition matches vinyls stored in the location at placeholder or anywhere inside it
+const locationSubtreeCondition = `location_id IN (
+	WITH RECURSIVE subtree(id) AS (
+		SELECT %[1]s::integer
+		UNION
+		SELECT l.id FROM locations l JOIN subtree s ON l.parent_id = s.id
+	) SELECT id FROM subtree)`
+
+// nullableID converts a nullable integer column into a JSON-friendly pointer
+func nullableID(n sql.NullInt64) *int {
+	if !n.Valid {
+		return nil
+	}
+	id := int(n.Int64)
+	return &id
+}
+
+// sameLocation reports whether two optional location ids are equal
+func sameLocation(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// recordLocationMove appends an entry to the location history
+func recordLocationMove(db dbExecutor, vinylID interface{}, from, to *int, userID int, note string) error {
+	_, err := db.Exec(`INSERT INTO location_moves (vinyl_id, from_location_id, to_location_id, user_id, note, moved_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())`, vinylID, from, to, userID, note)
+	return err
+}
+
+// moveVinyl stores a vinyl at a location, or nowhere if to is nil, and logs the move
+func moveVinyl(db dbExecutor, vinylID int, to *int, userID int, note string) error {
+	var current sql.NullInt64
+	if err := db.QueryRow("SELECT location_id FROM vinyls WHERE id = $1", vinylID).Scan(&current); err != nil {
+		return err
+	}
+	from := nullableID(current)
+	if sameLocation(from, to) {
+		return nil
+	}
+	if _, err := db.Exec("UPDATE vinyls SET location_id = $1 WHERE id = $2", to, vinylID); err != nil {
+		return err
+	}
+	return recordLocationMove(db, vinylID, from, to, userID, note)
+}
+
+// validateLocationParent checks that a location nests inside a larger kind of
+// location and, for an existing location (id > 0), that it is neither moved
+// into its own subtree nor changed to a kind its sub-locations cannot fit in
+func validateLocationParent(db dbExecutor, id int, kind string, parentID *int) error {
+	rank, ok := locationKinds[kind]
+	if !ok {
+		return fmt.Errorf("kind must be room, shelf, box or slot")
+	}
+	if id > 0 {
+		if err := validateLocationChildren(db, id, kind, rank); err != nil {
+			return err
+		}
+	}
+	if parentID == nil {
+		return nil
+	}
+	if *parentID == id {
+		return fmt.Errorf("a location cannot contain itself")
+	}
+	if id > 0 {
+		var inside bool
+		err := db.QueryRow(`WITH RECURSIVE subtree(id) AS (
+				SELECT $1::integer
+				UNION
+				SELECT l.id FROM locations l JOIN subtree s ON l.parent_id = s.id
+			) SELECT EXISTS (SELECT 1 FROM subtree WHERE id = $2)`, id, *parentID).Scan(&inside)
+		if err != nil {
+			log.Println(err)
+			return fmt.Errorf("failed to check sub-locations")
+		}
+		if inside {
+			return fmt.Errorf("a location cannot be placed inside one of its sub-locations")
+		}
+	}
+
+	var parentKind string
+	if err := db.QueryRow("SELECT kind FROM locations WHERE id = $1", *parentID).Scan(&parentKind); err != nil {
+		return fmt.Errorf("parent location not found")
+	}
+	if locationKinds[parentKind] >= rank {
+		return fmt.Errorf("a %s cannot be placed inside a %s", kind, parentKind)
+	}
+	return nil
+}
+
+// validateLocationChildren checks that every sub-location of a location is
+// of a smaller kind than the kind it is given
+func validateLocationChildren(db dbExecutor, id int, kind string, rank int) error {
+	rows, err := db.Query("SELECT DISTINCT kind FROM locations WHERE parent_id = $1", id)
+	if err != nil {
+		log.Println(err)
+		return fmt.Errorf("failed to check sub-locations")
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var childKind string
+		if err := rows.Scan(&childKind); err != nil {
+			log.Println(err)
+			return fmt.Errorf("failed to check sub-locations")
+		}
+		if locationKinds[childKind] <= rank {
+			return fmt.Errorf("a %s cannot contain a %s", kind, childKind)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Println(err)
+		return fmt.Errorf("failed to check sub-locations")
+	}
+	return nil
+}
+
+const locationSummaryQuery = `SELECT l.id, l.name, l.kind, l.parent_id, l.description,
+		(SELECT COUNT(*) FROM vinyls v WHERE v.location_id = l.id AND v.status = 'active')
+	FROM locations l`
+
+func scanLocation(row rowScanner) (Location, error) {
+	var l Location
+	var parentID sql.NullInt64
+	err := row.Scan(&l.ID, &l.Name, &l.Kind, &parentID, &l.Description, &l.VinylCount)
+	l.ParentID = nullableID(parentID)
+	return l, err
+}
+
+func queryLocations(db dbExecutor, query string, args ...interface{}) ([]Location, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	locations := []Location{}
+	for rows.Next() {
+		l, err := scanLocation(rows)
+		if err != nil {
+			return nil, err
+		}
+		locations = append(locations, l)
+	}
+	return locations, rows.Err()
+}
+
+// GetLocations lists all locations with the number of records stored directly in each
+func GetLocations(c *gin.Context) {
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	locations, err := queryLocations(db, locationSummaryQuery+" ORDER BY l.parent_id NULLS FIRST, l.name")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve locations"})
+		log.Println(err)
+		return
+	}
+
+	cachedJSON(c, locations)
+}
+
+// GetLocationByID returns a location with its path, sub-locations and contents.
+// With recursive=true the contents include records stored in sub-locations.
+func GetLocationByID(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+	recursive, _, err := queryBool(c, "recursive")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	location, err := scanLocation(db.QueryRow(locationSummaryQuery+" WHERE l.id = $1", id))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Location not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve location"})
+		log.Println(err)
+		return
+	}
+
+	// Breadcrumb from the room down to this location. Kinds nest strictly, so
+	// no path is deeper than there are kinds; the bound also ends the walk if
+	// the parents ever form a loop.
+	path, err := queryLocations(db, `WITH RECURSIVE ancestors(id, depth) AS (
+			SELECT $1::integer, 0
+			UNION
+			SELECT l.parent_id, a.depth + 1 FROM locations l JOIN ancestors a ON l.id = a.id
+			WHERE l.parent_id IS NOT NULL AND a.depth < $2
+		)`+locationSummaryQuery+` JOIN ancestors a ON a.id = l.id ORDER BY a.depth DESC`, id, len(locationKinds))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve location path"})
+		log.Println(err)
+		return
+	}
+
+	children, err := queryLocations(db, locationSummaryQuery+" WHERE l.parent_id = $1 ORDER BY l.name", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sub-locations"})
+		log.Println(err)
+		return
+	}
+
+	filter := newVinylFilter()
+	if recursive {
+		filter.add(fmt.Sprintf(locationSubtreeCondition, filter.arg(id)))
+	} else {
+		filter.add("location_id = " + filter.arg(id))
+	}
+	rows, err := db.Query("SELECT "+vinylColumns+" FROM vinyls"+filter.where()+" ORDER BY artist ASC, title ASC", filter.args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve data"})
+		log.Println(err)
+		return
+	}
+	defer rows.Close()
+
+	vinyls := []Vinyl{}
+	for rows.Next() {
+		v, err := scanVinyl(rows)
+		if err != nil {
+			fmt.Printf("Error scanning data: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error scanning data"})
+			return
+		}
+		vinyls = append(vinyls, v)
+	}
+
+	cachedJSON(c, gin.H{
+		"location": location,
+		"path":     path,
+		"children": children,
+		"vinyls":   vinyls,
+	})
+}
+
+type locationRequest struct {
+	Name        string `json:"name"`
+	Kind        string `json:"kind"`
+	ParentID    *int   `json:"parent_id"`
+	Description string `json:"description"`
+}
+
+// CreateLocation adds a room, shelf, box or slot
+func CreateLocation(c *gin.Context) {
+	var req locationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Location name cannot be empty"})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	if err := validateLocationParent(db, 0, req.Kind, req.ParentID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var id int
+	err = db.QueryRow("INSERT INTO locations (name, kind, parent_id, description) VALUES ($1, $2, $3, $4) RETURNING id",
+		req.Name, req.Kind, req.ParentID, req.Description).Scan(&id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert location"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Location created successfully", "id": id})
+}
+
+// UpdateLocation renames, re-describes or re-parents a location
+func UpdateLocation(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	var req locationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Location name cannot be empty"})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	if err := validateLocationParent(db, id, req.Kind, req.ParentID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := db.Exec("UPDATE locations SET name = $1, kind = $2, parent_id = $3, description = $4 WHERE id = $5",
+		req.Name, req.Kind, req.ParentID, req.Description, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update location"})
+		log.Println(err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Location not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Location updated successfully", "id": id})
+}
+
+// DeleteLocation removes an empty location
+func DeleteLocation(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	var vinylCount, childCount int
+	err = db.QueryRow(`SELECT
+			(SELECT COUNT(*) FROM vinyls WHERE location_id = $1),
+			(SELECT COUNT(*) FROM locations WHERE parent_id = $1)`, id).Scan(&vinylCount, &childCount)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check location contents"})
+		log.Println(err)
+		return
+	}
+	if vinylCount > 0 || childCount > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Location is not empty", "vinyls": vinylCount, "children": childCount})
+		return
+	}
+
+	result, err := db.Exec("DELETE FROM locations WHERE id = $1", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete location"})
+		log.Println(err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Location not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Location id = %d deleted successfully", id)})
+}
+
+// MoveVinyl stores a vinyl at a new location (or none, with a null location_id) and logs the move
+func MoveVinyl(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		LocationID *int   `json:"location_id"`
+		Note       string `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	if req.LocationID != nil {
+		var exists bool
+		if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM locations WHERE id = $1)", *req.LocationID).Scan(&exists); err != nil || !exists {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Location not found"})
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	err = moveVinyl(tx, id, req.LocationID, c.MustGet("user_id").(int), strings.TrimSpace(req.Note))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Vinyl not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move vinyl"})
+		log.Println(err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit move"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Vinyl moved successfully", "id": id, "location_id": req.LocationID})
+}
+
+// GetLocationHistory lists the moves of a vinyl, newest first
+func GetLocationHistory(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`
+		SELECT m.id, m.vinyl_id, m.from_location_id, m.to_location_id, m.note, COALESCE(u.username, ''), m.moved_at
+		FROM location_moves m
+		LEFT JOIN users u ON m.user_id = u.id
+		WHERE m.vinyl_id = $1
+		ORDER BY m.moved_at DESC, m.id DESC`, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve location history"})
+		log.Println(err)
+		return
+	}
+	defer rows.Close()
+
+	moves := []LocationMove{}
+	for rows.Next() {
+		var m LocationMove
+		var from, to sql.NullInt64
+		if err := rows.Scan(&m.ID, &m.VinylID, &from, &to, &m.Note, &m.Username, &m.MovedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error scanning data"})
+			log.Println(err)
+			return
+		}
+		m.FromLocationID = nullableID(from)
+		m.ToLocationID = nullableID(to)
+		moves = append(moves, m)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error iterating rows"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"vinyl_id": id, "moves": moves})
+}
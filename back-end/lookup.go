@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IdentifierWarning reports an active vinyl that shares a barcode or
+// catalog number with the one being added
+type IdentifierWarning struct {
+	Field   string `json:"field"` // "barcode" or "catalog_number"
+	Value   string `json:"value"`
+	VinylID int    `json:"vinyl_id"`
+	Title   string `json:"title"`
+	Artist  string `json:"artist"`
+}
+
+// findDuplicateIdentifiers looks for other active vinyls with the same barcode,
+// or the same catalog number on the same label. Catalog numbers are only
+// unique per label, so an empty label on either side still counts as a match.
+func findDuplicateIdentifiers(db *sql.DB, vinyl Vinyl) ([]IdentifierWarning, error) {
+	warnings := []IdentifierWarning{}
+
+	if vinyl.Barcode != "" {
+		rows, err := db.Query(`SELECT id, title, artist FROM vinyls
+			WHERE status = 'active' AND id <> $1 AND barcode = $2`, vinyl.ID, vinyl.Barcode)
+		if err != nil {
+			return warnings, err
+		}
+		for rows.Next() {
+			w := IdentifierWarning{Field: "barcode", Value: vinyl.Barcode}
+			if err := rows.Scan(&w.VinylID, &w.Title, &w.Artist); err != nil {
+				rows.Close()
+				return warnings, err
+			}
+			warnings = append(warnings, w)
+		}
+		rows.Close()
+	}
+
+	if vinyl.CatalogNumber != "" {
+		rows, err := db.Query(`SELECT id, title, artist FROM vinyls
+			WHERE status = 'active' AND id <> $1 AND LOWER(catalog_number) = LOWER($2)
+			AND (label = '' OR $3 = '' OR LOWER(label) = LOWER($3))`, vinyl.ID, vinyl.CatalogNumber, strings.TrimSpace(vinyl.Label))
+		if err != nil {
+			return warnings, err
+		}
+		for rows.Next() {
+			w := IdentifierWarning{Field: "catalog_number", Value: vinyl.CatalogNumber}
+			if err := rows.Scan(&w.VinylID, &w.Title, &w.Artist); err != nil {
+				rows.Close()
+				return warnings, err
+			}
+			warnings = append(warnings, w)
+		}
+		rows.Close()
+	}
+
+	return warnings, nil
+}
+
+// LookupVinyl answers "do I already own this" by barcode or catalog number
+func LookupVinyl(c *gin.Context) {
+	barcode := normalizeBarcode(c.Query("barcode"))
+	catalogNumber := strings.TrimSpace(c.Query("catalog_number"))
+
+	if barcode == "" && catalogNumber == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing barcode or catalog_number"})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	query := "SELECT " + vinylColumns + " FROM vinyls WHERE status = 'active' AND ((barcode <> '' AND barcode = $1) OR (catalog_number <> '' AND LOWER(catalog_number) = LOWER($2))) ORDER BY id ASC"
+	rows, err := db.Query(query, barcode, catalogNumber)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve data"})
+		return
+	}
+	defer rows.Close()
+
+	vinyls := []Vinyl{}
+	for rows.Next() {
+		v, err := scanVinyl(rows)
+		if err != nil {
+			fmt.Printf("Error scanning data: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error scanning data"})
+			return
+		}
+		vinyls = append(vinyls, v)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"owned": len(vinyls) > 0, "vinyls": vinyls})
+}
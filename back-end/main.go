@@ -16,9 +16,18 @@ func init() {
 	loadEnvVariables()
 	secretKey = []byte(os.Getenv("SECRET_KEY"))
 	listen_address = os.Getenv("GO_PORT")
+	metadataProviders = loadMetadataProviders()
+	albumStore = mustLoadBlobStore()
 }
 
 func main() {
+	// Maintenance commands run instead of the server
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
 	router := gin.Default()
 
@@ -42,6 +51,8 @@ func main() {
 			"X-Real-IP",
 			"X-Forwarded-For",
 			"X-Forwarded-Proto",
+			"If-None-Match",
+			"If-Modified-Since",
 		},
 		ExposeHeaders: []string{
 			"Content-Length",
@@ -50,6 +61,8 @@ func main() {
 			"Cache-Control",
 			"Content-Language",
 			"Content-Type",
+			"ETag",
+			"Last-Modified",
 		},
 		AllowCredentials: true,
 		MaxAge:           12 * 60 * 60, // 12 hours
@@ -66,9 +79,29 @@ func main() {
 
 		// Public vinyl routes
 		api.GET("/vinyls", GetVinylInfo)
+		api.GET("/vinyls/lookup", LookupVinyl)
+		api.GET("/vinyls/search", SearchVinyls)
+		api.GET("/vinyls/facets", GetVinylFacets)
+		api.GET("/vinyls/disposed", GetDisposedVinyls)
 		api.GET("/vinyls/:id", GetVinylByID)
 		api.GET("/album/:filename", ServeAlbumPicture)
 		api.GET("/history/:id", GetPlayHistoryByID)
+		api.GET("/vinyls/:id/condition", GetConditionHistory)
+		api.GET("/artists", GetArtists)
+		api.GET("/artists/:id", GetArtistByID)
+		api.GET("/tags", GetTags)
+		api.GET("/locations", GetLocations)
+		api.GET("/locations/:id", GetLocationByID)
+		api.GET("/vinyls/:id/moves", GetLocationHistory)
+		api.GET("/audits", GetAuditSessions)
+		api.GET("/audits/:id", GetAuditSession)
+		api.GET("/wishlist", GetWishlist)
+		api.GET("/wishlist/:id", GetWishlistItem)
+		api.GET("/valuation", GetValuation)
+		api.GET("/valuation/market", GetMarketValuation)
+		api.GET("/vinyls/:id/prices", GetPriceHistory)
+		api.GET("/vinyls/:id/images", GetVinylImages)
+		api.GET("/sellers", GetSellers)
 		// Version information
 		api.GET("/version", GetVersion)
 
@@ -80,10 +113,81 @@ func main() {
 			protected.POST("/vinyls", AddVinyl)
 			protected.PUT("/vinyls/:id", UpdateVinyl)
 			protected.DELETE("/vinyls/:id", DeleteVinyl)
+			protected.POST("/vinyls/:id/dispose", DisposeVinyl)
+			protected.POST("/vinyls/:id/reinstate", ReinstateVinyl)
 			protected.POST("/vinyls/play", AddPlayNum)
+			protected.POST("/vinyls/:id/condition", UpdateVinylCondition)
+
+			// Artist management
+			protected.POST("/artists", CreateArtist)
+			protected.PUT("/artists/:id", UpdateArtist)
+			protected.POST("/artists/:id/aliases", AddArtistAlias)
+			protected.DELETE("/artists/:id/aliases/:alias", DeleteArtistAlias)
+			protected.POST("/artists/:id/merge", MergeArtists)
+			protected.POST("/artists/link", LinkUnlinkedVinyls)
+
+			// Genres, styles and tags
+			protected.POST("/tags", CreateTag)
+			protected.PUT("/tags/:id", UpdateTag)
+			protected.DELETE("/tags/:id", DeleteTag)
+			protected.POST("/tags/assign", AssignTags)
+			protected.POST("/tags/remove", RemoveTags)
+
+			// Storage locations
+			protected.POST("/locations", CreateLocation)
+			protected.PUT("/locations/:id", UpdateLocation)
+			protected.DELETE("/locations/:id", DeleteLocation)
+			protected.POST("/vinyls/:id/move", MoveVinyl)
+
+			// Inventory audits
+			protected.POST("/audits", StartAudit)
+			protected.POST("/audits/:id/scans", ScanAuditRecord)
+			protected.DELETE("/audits/:id/scans/:scan_id", DeleteAuditScan)
+			protected.POST("/audits/:id/close", CloseAudit)
+
+			// Loans
+			protected.GET("/loans", GetLoans)
+			protected.GET("/loans/overdue", GetOverdueLoans)
+			protected.GET("/vinyls/:id/loans", GetLoanHistory)
+			protected.POST("/vinyls/:id/loan", LendVinyl)
+			protected.POST("/vinyls/:id/return", ReturnVinyl)
+
+			// Wishlist
+			protected.POST("/wishlist", AddWishlistItem)
+			protected.PUT("/wishlist/:id", UpdateWishlistItem)
+			protected.DELETE("/wishlist/:id", DeleteWishlistItem)
+			protected.POST("/wishlist/:id/convert", ConvertWishlistItem)
+
+			// Market value estimates
+			protected.POST("/vinyls/:id/prices", AddPriceObservation)
+			protected.DELETE("/prices/:id", DeletePriceObservation)
+
+			// Reports
+			protected.GET("/reports/insurance", GetInsuranceReport)
+			protected.GET("/reports/spend-by-seller", GetSpendBySeller)
+			protected.GET("/reports/realized", GetRealizedGains)
+			protected.GET("/reports/duplicate-covers", GetDuplicateCovers)
+
+			// Sellers
+			protected.POST("/sellers", CreateSeller)
+			protected.PUT("/sellers/:id", UpdateSeller)
+			protected.DELETE("/sellers/:id", DeleteSeller)
 
 			// File upload
 			protected.POST("/upload", UploadAlbumPicture)
+			protected.POST("/images/migrate", MigrateAlbumImages)
+			protected.GET("/images/gc", CollectOrphanedImages)
+			protected.POST("/images/gc", CollectOrphanedImages)
+			protected.POST("/images/placeholders", BackfillImagePlaceholders)
+			protected.POST("/vinyls/:id/images", AddVinylImage)
+			protected.PUT("/vinyls/:id/images/order", ReorderVinylImages)
+			protected.PUT("/vinyls/:id/images/:hash", UpdateVinylImage)
+			protected.DELETE("/vinyls/:id/images/:hash", DeleteVinylImage)
+
+			// Metadata import
+			protected.POST("/import/musicbrainz", ImportMusicBrainzRelease)
+			protected.GET("/metadata/:provider/search", SearchMetadata)
+			protected.GET("/metadata/:provider/releases/:id", FetchMetadataRelease)
 
 			// User management
 			protected.POST("/auth/changepwd", ChangePassword)
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PriceObservation is an estimate of what a vinyl is worth on a given date,
+// e.g. a Discogs median or a shop offer
+type PriceObservation struct {
+	ID         int     `json:"id"`
+	VinylID    int     `json:"vinyl_id"`
+	ObservedAt string  `json:"observed_at"` // "2006-01-02"
+	Value      float64 `json:"value"`
+	Currency   string  `json:"currency"`
+	Source     string  `json:"source"`
+	Note       string  `json:"note"`
+}
+
+// MarketValue compares the latest estimate of a vinyl with its purchase price.
+// Converted amounts and GainLoss are null when a currency has no exchange rate.
+type MarketValue struct {
+	VinylID         int      `json:"vinyl_id"`
+	Title           string   `json:"title"`
+	Artist          string   `json:"artist"`
+	MediaCondition  string   `json:"media_condition"`
+	SleeveCondition string   `json:"sleeve_condition"`
+	Price           float64  `json:"price"`
+	PriceCurrency   string   `json:"price_currency"`
+	Value           float64  `json:"value"`
+	ValueCurrency   string   `json:"value_currency"`
+	ObservedAt      string   `json:"observed_at"`
+	Source          string   `json:"source"`
+	PriceConverted  *float64 `json:"price_converted"`
+	ValueConverted  *float64 `json:"value_converted"`
+	GainLoss        *float64 `json:"gain_loss"`
+}
+
+// convertMoney converts and rounds an amount, returning nil if it cannot be converted
+func convertMoney(rates ExchangeRates, amount float64, from, to string) *float64 {
+	converted, ok := rates.convert(amount, strings.ToUpper(strings.TrimSpace(from)), to)
+	if !ok {
+		return nil
+	}
+	converted = roundMoney(converted)
+	return &converted
+}
+
+// computeGainLoss fills the converted amounts and gain/loss of a market value
+func (m *MarketValue) computeGainLoss(rates ExchangeRates, target string) {
+	m.PriceConverted = convertMoney(rates, m.Price, m.PriceCurrency, target)
+	m.ValueConverted = convertMoney(rates, m.Value, m.ValueCurrency, target)
+	if m.PriceConverted != nil && m.ValueConverted != nil {
+		gain := roundMoney(*m.ValueConverted - *m.PriceConverted)
+		m.GainLoss = &gain
+	}
+}
+
+// latestObservationsQuery selects the newest observation of every vinyl
+const latestObservationsQuery = `SELECT DISTINCT ON (vinyl_id) vinyl_id, TO_CHAR(observed_at, 'YYYY-MM-DD'), value, currency, source
+	FROM price_observations
+	ORDER BY vinyl_id, observed_at DESC, id DESC`
+
+// AddPriceObservation records an estimate of a vinyl's current value
+func AddPriceObservation(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	var obs PriceObservation
+	if err := c.ShouldBindJSON(&obs); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	if obs.Value < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "value cannot be negative"})
+		return
+	}
+	obs.Currency = strings.ToUpper(strings.TrimSpace(obs.Currency))
+	if obs.Currency == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "currency cannot be empty"})
+		return
+	}
+	var err error
+	if obs.ObservedAt, err = parseDateField("observed_at", obs.ObservedAt, true); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM vinyls WHERE id = $1)", id).Scan(&exists); err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Vinyl not found"})
+		return
+	}
+
+	err = db.QueryRow(`INSERT INTO price_observations (vinyl_id, observed_at, value, currency, source, note, user_id)
+		VALUES ($1, $2::date, $3, $4, $5, $6, $7) RETURNING id`,
+		id, obs.ObservedAt, obs.Value, obs.Currency, strings.TrimSpace(obs.Source), strings.TrimSpace(obs.Note), c.MustGet("user_id").(int)).Scan(&obs.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record price observation"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Price observation recorded successfully", "id": obs.ID})
+}
+
+// DeletePriceObservation removes an estimate entered by mistake
+func DeletePriceObservation(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	result, err := db.Exec("DELETE FROM price_observations WHERE id = $1", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete price observation"})
+		log.Println(err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Price observation not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Price observation id = %d deleted successfully", id)})
+}
+
+// GetPriceHistory lists the value estimates of a vinyl, newest first, with
+// the gain or loss of the latest estimate against the purchase price
+func GetPriceHistory(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	rates, err := loadExchangeRates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load exchange rates"})
+		log.Println(err)
+		return
+	}
+	target, ok := valuationCurrency(c, rates)
+	if !ok {
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	current := MarketValue{VinylID: id}
+	err = db.QueryRow("SELECT title, artist, media_condition, sleeve_condition, COALESCE(price, 0), COALESCE(currency, '') FROM vinyls WHERE id = $1", id).
+		Scan(&current.Title, &current.Artist, &current.MediaCondition, &current.SleeveCondition, &current.Price, &current.PriceCurrency)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Vinyl not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve data"})
+		log.Println(err)
+		return
+	}
+
+	rows, err := db.Query(`SELECT id, vinyl_id, TO_CHAR(observed_at, 'YYYY-MM-DD'), value, currency, source, note
+		FROM price_observations WHERE vinyl_id = $1
+		ORDER BY observed_at DESC, id DESC`, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve price history"})
+		log.Println(err)
+		return
+	}
+	defer rows.Close()
+
+	history := []PriceObservation{}
+	for rows.Next() {
+		var obs PriceObservation
+		if err := rows.Scan(&obs.ID, &obs.VinylID, &obs.ObservedAt, &obs.Value, &obs.Currency, &obs.Source, &obs.Note); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error scanning data"})
+			log.Println(err)
+			return
+		}
+		history = append(history, obs)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error iterating rows"})
+		return
+	}
+
+	response := gin.H{"vinyl_id": id, "currency": target, "prices": history, "current": nil}
+	if len(history) > 0 {
+		latest := history[0]
+		current.Value, current.ValueCurrency = latest.Value, latest.Currency
+		current.ObservedAt, current.Source = latest.ObservedAt, latest.Source
+		current.computeGainLoss(rates, target)
+		response["current"] = current
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// collectionMarketValues returns the latest estimate of every active vinyl
+// matching the filter, and the number of matching vinyls without any estimate
+func collectionMarketValues(db dbExecutor, filter *vinylFilter) ([]MarketValue, int, error) {
+	rows, err := db.Query(`SELECT v.id, v.title, v.artist, v.media_condition, v.sleeve_condition, COALESCE(v.price, 0), COALESCE(v.currency, ''),
+			o.value, o.currency, o.observed_at, o.source
+		FROM (SELECT id, title, artist, media_condition, sleeve_condition, price, currency FROM vinyls`+filter.where()+`) v
+		LEFT JOIN (`+latestObservationsQuery+`) o (vinyl_id, observed_at, value, currency, source) ON o.vinyl_id = v.id
+		ORDER BY v.id`, filter.args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	values := []MarketValue{}
+	unvalued := 0
+	for rows.Next() {
+		var m MarketValue
+		var value sql.NullFloat64
+		var currency, observedAt, source sql.NullString
+		if err := rows.Scan(&m.VinylID, &m.Title, &m.Artist, &m.MediaCondition, &m.SleeveCondition, &m.Price, &m.PriceCurrency, &value, &currency, &observedAt, &source); err != nil {
+			return nil, 0, err
+		}
+		if !value.Valid {
+			unvalued++
+			continue
+		}
+		m.Value, m.ValueCurrency = value.Float64, currency.String
+		m.ObservedAt, m.Source = observedAt.String, source.String
+		values = append(values, m)
+	}
+	return values, unvalued, rows.Err()
+}
+
+// GetMarketValuation estimates what the active collection is worth now from
+// the latest price observation of each vinyl, for insurance. It totals the
+// estimates, purchase prices and gain or loss in one currency over the
+// estimated vinyls whose estimate and price both convert, so that
+// estimated_value - purchase_cost = gain_loss; the others are listed in
+// excluded by id and their estimates in unconverted. It also breaks the
+// estimates down by media condition and lists every estimated vinyl, most
+// valuable first. It accepts the same filters as the vinyl list.
+func GetMarketValuation(c *gin.Context) {
+	rates, err := loadExchangeRates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load exchange rates"})
+		log.Println(err)
+		return
+	}
+	target, ok := valuationCurrency(c, rates)
+	if !ok {
+		return
+	}
+
+	filter, err := parseVinylFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	values, unvalued, err := collectionMarketValues(db, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve market values"})
+		log.Println(err)
+		return
+	}
+
+	var estimatedValue, purchaseCost, gainLoss float64
+	unconverted := map[string]float64{}
+	excluded := []int{}
+	byCondition := valuationBreakdown{}
+	for i := range values {
+		m := &values[i]
+		m.computeGainLoss(rates, target)
+		byCondition.group(conditionKey(m.MediaCondition)).add(1, m.Value, strings.ToUpper(strings.TrimSpace(m.ValueCurrency)), rates, target)
+		if m.ValueConverted == nil {
+			unconverted[m.ValueCurrency] = roundMoney(unconverted[m.ValueCurrency] + m.Value)
+		}
+		if m.GainLoss == nil {
+			excluded = append(excluded, m.VinylID)
+			continue
+		}
+		estimatedValue += *m.ValueConverted
+		purchaseCost += *m.PriceConverted
+		gainLoss += *m.GainLoss
+	}
+	sortMarketValues(values)
+
+	c.JSON(http.StatusOK, gin.H{
+		"currency":        target,
+		"rates_date":      rates.Date,
+		"estimated_value": roundMoney(estimatedValue),
+		"purchase_cost":   roundMoney(purchaseCost),
+		"gain_loss":       roundMoney(gainLoss),
+		"valued":          len(values),
+		"unvalued":        unvalued,
+		"unconverted":     unconverted,
+		"excluded":        excluded,
+		"by_condition":    byCondition.sorted(false),
+		"vinyls":          values,
+	})
+}
+
+// sortMarketValues orders vinyls by converted value, largest first, with
+// unconvertible values last
+func sortMarketValues(values []MarketValue) {
+	sort.SliceStable(values, func(i, j int) bool {
+		a, b := values[i].ValueConverted, values[j].ValueConverted
+		if a == nil || b == nil {
+			return a != nil && b == nil
+		}
+		return *a > *b
+	})
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrReleaseNotFound is returned by a MetadataProvider when the release id is unknown
+var ErrReleaseNotFound = errors.New("release not found")
+
+// MetadataQuery holds the search terms for a metadata lookup.
+// Providers ignore empty fields.
+type MetadataQuery struct {
+	Artist  string `json:"artist"`
+	Title   string `json:"title"`
+	Barcode string `json:"barcode"`
+}
+
+// MetadataResult is a single search hit from a metadata provider
+type MetadataResult struct {
+	Provider      string `json:"provider"`
+	ID            string `json:"id"`
+	Title         string `json:"title"`
+	Artist        string `json:"artist"`
+	Year          int    `json:"year"`
+	Country       string `json:"country"`
+	Label         string `json:"label"`
+	CatalogNumber string `json:"catalog_number"`
+	Barcode       string `json:"barcode"`
+	Format        string `json:"format"`
+	ThumbURL      string `json:"thumb_url"`
+}
+
+// MetadataProvider looks up release metadata in an external catalogue
+type MetadataProvider interface {
+	// Name is the identifier used in the /api/metadata/:provider routes
+	Name() string
+	// Search returns candidate releases matching the query
+	Search(ctx context.Context, query MetadataQuery) ([]MetadataResult, error)
+	// FetchRelease returns the release as a prefilled Vinyl ready for review
+	FetchRelease(ctx context.Context, id string) (Vinyl, error)
+}
+
+// metadataProviders holds the configured providers, keyed by Name()
+var metadataProviders = map[string]MetadataProvider{}
+
+// loadMetadataProviders builds the providers from environment variables.
+// Base URLs default to the public services and can point at a local mock.
+func loadMetadataProviders() map[string]MetadataProvider {
+	client := &http.Client{Timeout: 15 * time.Second}
+	userAgent := os.Getenv("METADATA_USER_AGENT")
+	if userAgent == "" {
+		// MusicBrainz rejects requests without a meaningful User-Agent
+		userAgent = fmt.Sprintf("VinyLibrary/%s (https://github.com/Doublefire-Chen/VinyLibrary)", Version)
+	}
+
+	musicBrainzBaseURL := os.Getenv("MUSICBRAINZ_BASE_URL")
+	if musicBrainzBaseURL == "" {
+		musicBrainzBaseURL = "https://musicbrainz.org/ws/2"
+	}
+	discogsBaseURL := os.Getenv("DISCOGS_BASE_URL")
+	if discogsBaseURL == "" {
+		discogsBaseURL = "https://api.discogs.com"
+	}
+
+	providers := []MetadataProvider{
+		&musicBrainzProvider{
+			baseURL:   strings.TrimSuffix(musicBrainzBaseURL, "/"),
+			userAgent: userAgent,
+			client:    client,
+		},
+		&discogsProvider{
+			baseURL:   strings.TrimSuffix(discogsBaseURL, "/"),
+			token:     os.Getenv("DISCOGS_TOKEN"),
+			userAgent: userAgent,
+			client:    client,
+		},
+	}
+
+	result := make(map[string]MetadataProvider, len(providers))
+	for _, p := range providers {
+		result[p.Name()] = p
+	}
+	return result
+}
+
+// discSizePattern matches the inch size in format strings like `12" Vinyl`
+var discSizePattern = regexp.MustCompile(`\b(7|10|12)(?:"|''|”| ?inch)`)
+
+// parseDiscSize extracts the disc size in inches from a format description, 0 if none
+func parseDiscSize(format string) int {
+	match := discSizePattern.FindStringSubmatch(format)
+	if match == nil {
+		return 0
+	}
+	size, _ := strconv.Atoi(match[1])
+	return size
+}
+
+// parseRPM extracts the speed from a format description such as "33 ⅓ RPM", 0 if none
+func parseRPM(format string) int {
+	format = strings.ToUpper(format)
+	if !strings.Contains(format, "RPM") {
+		return 0
+	}
+	for _, rpm := range []int{33, 45, 78} {
+		if strings.HasPrefix(format, strconv.Itoa(rpm)) {
+			return rpm
+		}
+	}
+	return 0
+}
+
+// statusError is an unexpected HTTP status from a catalogue
+type statusError struct {
+	status int
+	host   string
+	body   string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status %d from %s: %s", e.status, e.host, e.body)
+}
+
+// fetchJSON performs a GET request and decodes the JSON response into out
+func fetchJSON(ctx context.Context, client *http.Client, rawURL string, header http.Header, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrReleaseNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &statusError{status: resp.StatusCode, host: req.URL.Host, body: strings.TrimSpace(string(snippet))}
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// getMetadataProvider resolves the :provider route parameter, writing a 404 if unknown
+func getMetadataProvider(c *gin.Context) (MetadataProvider, bool) {
+	provider, ok := metadataProviders[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown metadata provider"})
+		return nil, false
+	}
+	return provider, true
+}
+
+// SearchMetadata searches an external catalogue by artist, title and/or barcode
+func SearchMetadata(c *gin.Context) {
+	provider, ok := getMetadataProvider(c)
+	if !ok {
+		return
+	}
+
+	query := MetadataQuery{
+		Artist:  strings.TrimSpace(c.Query("artist")),
+		Title:   strings.TrimSpace(c.Query("title")),
+		Barcode: strings.TrimSpace(c.Query("barcode")),
+	}
+	if query.Artist == "" && query.Title == "" && query.Barcode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing artist, title or barcode"})
+		return
+	}
+
+	results, err := provider.Search(c.Request.Context(), query)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Metadata search failed"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"provider": provider.Name(), "results": results})
+}
+
+// FetchMetadataRelease fetches one release from an external catalogue as a prefilled Vinyl
+func FetchMetadataRelease(c *gin.Context) {
+	provider, ok := getMetadataProvider(c)
+	if !ok {
+		return
+	}
+
+	id := c.Param("id")
+	vinyl, err := provider.FetchRelease(c.Request.Context(), id)
+	if errors.Is(err, ErrReleaseNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Release not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to fetch release"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"provider": provider.Name(), "id": id, "vinyl": vinyl})
+}
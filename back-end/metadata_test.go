@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// newMetadataServer serves canned JSON documents by request path, answering
+// status for any other path
+func newMetadataServer(t *testing.T, status int, documents map[string]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != "VinyLibrary-test" {
+			t.Errorf("User-Agent = %q", r.Header.Get("User-Agent"))
+		}
+		document, ok := documents[r.URL.Path]
+		if !ok {
+			w.WriteHeader(status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(document))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+const musicBrainzReleaseJSON = `{
+	"id": "b84ee12a-09ef-421b-82de-0441a926375b",
+	"title": "Abbey Road",
+	"date": "1969-09-26",
+	"country": "GB",
+	"barcode": "",
+	"artist-credit": [{"name": "The Beatles", "joinphrase": "", "artist": {"id": "b10bbbfc", "name": "The Beatles"}}],
+	"label-info": [{"catalog-number": "PCS 7088", "label": {"name": "Apple Records"}}],
+	"release-group": {"primary-type": "Album"},
+	"media": [
+		{"position": 1, "format": "12\" Vinyl", "tracks": [
+			{"number": "A1", "position": 1, "title": "Come Together", "length": 259946},
+			{"number": "A2", "position": 2, "title": "", "length": null, "recording": {"title": "Something", "length": 182293}},
+			{"number": "B", "position": 3, "title": "Here Comes the Sun", "length": 185733}
+		]},
+		{"position": 2, "format": "CD", "tracks": [
+			{"number": "1", "position": 1, "title": "Come Together", "length": 259946}
+		]}
+	]
+}`
+
+func TestMusicBrainzFetchRelease(t *testing.T) {
+	server := newMetadataServer(t, http.StatusBadRequest, map[string]string{
+		"/release/b84ee12a-09ef-421b-82de-0441a926375b": musicBrainzReleaseJSON,
+	})
+	provider := &musicBrainzProvider{baseURL: server.URL, userAgent: "VinyLibrary-test", client: server.Client()}
+
+	vinyl, err := provider.FetchRelease(context.Background(), "b84ee12a-09ef-421b-82de-0441a926375b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vinyl.Title != "Abbey Road" || vinyl.Artist != "The Beatles" || vinyl.Year != 1969 ||
+		vinyl.ReleaseDate != "1969-09-26" || vinyl.Country != "GB" {
+		t.Errorf("release fields = %q %q %d %q %q", vinyl.Title, vinyl.Artist, vinyl.Year, vinyl.ReleaseDate, vinyl.Country)
+	}
+	if vinyl.Label != "Apple Records" || vinyl.CatalogNumber != "PCS 7088" || vinyl.VinylType != "LP" {
+		t.Errorf("label fields = %q %q %q", vinyl.Label, vinyl.CatalogNumber, vinyl.VinylType)
+	}
+	// The bundled CD is not a vinyl medium
+	if vinyl.VinylNumber != 1 || vinyl.DiscSize != 12 {
+		t.Errorf("VinylNumber = %d, DiscSize = %d", vinyl.VinylNumber, vinyl.DiscSize)
+	}
+	want := []Track{
+		{Side: "A", Order: 1, Title: "Come Together", Length: "4:20"},
+		{Side: "A", Order: 2, Title: "Something", Length: "3:02"},
+		{Side: "B", Order: 1, Title: "Here Comes the Sun", Length: "3:06"},
+	}
+	if !reflect.DeepEqual(vinyl.Tracklist, want) {
+		t.Errorf("Tracklist = %+v, want %+v", vinyl.Tracklist, want)
+	}
+}
+
+func TestMusicBrainzFetchReleaseNotFound(t *testing.T) {
+	// MusicBrainz answers 400 for an id that is not an MBID and 404 for an unknown one
+	for _, status := range []int{http.StatusBadRequest, http.StatusNotFound} {
+		server := newMetadataServer(t, status, nil)
+		provider := &musicBrainzProvider{baseURL: server.URL, userAgent: "VinyLibrary-test", client: server.Client()}
+		if _, err := provider.FetchRelease(context.Background(), "not-an-mbid"); !errors.Is(err, ErrReleaseNotFound) {
+			t.Errorf("status %d: err = %v, want ErrReleaseNotFound", status, err)
+		}
+	}
+}
+
+func TestMusicBrainzSearchBadRequest(t *testing.T) {
+	// Only a release lookup treats 400 as an unknown id; a rejected search is an error
+	server := newMetadataServer(t, http.StatusBadRequest, nil)
+	provider := &musicBrainzProvider{baseURL: server.URL, userAgent: "VinyLibrary-test", client: server.Client()}
+	_, err := provider.Search(context.Background(), MetadataQuery{Title: "Abbey Road"})
+	if err == nil || errors.Is(err, ErrReleaseNotFound) {
+		t.Errorf("err = %v, want a status error", err)
+	}
+}
+
+const discogsReleaseJSON = `{
+	"id": 249504,
+	"title": "Bridge Over Troubled Water",
+	"year": 1970,
+	"released": "1970-01-26",
+	"country": "US",
+	"artists": [
+		{"name": "Simon (2)", "anv": "", "join": "&"},
+		{"name": "Garfunkel", "anv": "", "join": ""}
+	],
+	"labels": [{"name": "Columbia (3)", "catno": "KCS 9914"}],
+	"formats": [{"name": "Vinyl", "qty": "1", "descriptions": ["LP", "Album", "12\"", "33 ⅓ RPM"], "text": "Black"}],
+	"identifiers": [
+		{"type": "Matrix / Runout", "value": "XSM-150127"},
+		{"type": "Barcode", "value": "0 7464-09914-1 4"}
+	],
+	"tracklist": [
+		{"position": "", "type_": "heading", "title": "Side One", "duration": ""},
+		{"position": "A1", "type_": "track", "title": "Bridge Over Troubled Water", "duration": "4:52"},
+		{"position": "B1", "type_": "track", "title": "The Boxer", "duration": "5:08"}
+	]
+}`
+
+const discogsSearchJSON = `{"results": [{
+	"id": 249504,
+	"title": "Simon & Garfunkel (2) - Bridge Over Troubled Water",
+	"year": "1970",
+	"country": "US",
+	"label": ["Columbia", "CBS"],
+	"catno": "KCS 9914",
+	"barcode": ["074640991414"],
+	"format": ["Vinyl", "LP", "Album"],
+	"thumb": "https://img.example/249504.jpg"
+}]}`
+
+func TestDiscogsFetchRelease(t *testing.T) {
+	server := newMetadataServer(t, http.StatusNotFound, map[string]string{
+		"/releases/249504": discogsReleaseJSON,
+	})
+	provider := &discogsProvider{baseURL: server.URL, userAgent: "VinyLibrary-test", client: server.Client()}
+
+	vinyl, err := provider.FetchRelease(context.Background(), "249504")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vinyl.Title != "Bridge Over Troubled Water" || vinyl.Artist != "Simon & Garfunkel" || vinyl.Year != 1970 ||
+		vinyl.ReleaseDate != "1970-01-26" || vinyl.Country != "US" {
+		t.Errorf("release fields = %q %q %d %q %q", vinyl.Title, vinyl.Artist, vinyl.Year, vinyl.ReleaseDate, vinyl.Country)
+	}
+	if vinyl.Label != "Columbia" || vinyl.CatalogNumber != "KCS 9914" || vinyl.Barcode != normalizeBarcode("0 7464-09914-1 4") {
+		t.Errorf("label fields = %q %q %q", vinyl.Label, vinyl.CatalogNumber, vinyl.Barcode)
+	}
+	if vinyl.VinylType != "LP" || vinyl.VinylNumber != 1 || vinyl.DiscSize != 12 || vinyl.RPM != 33 || vinyl.ColorVariant != "Black" {
+		t.Errorf("format fields = %q %d %d %d %q", vinyl.VinylType, vinyl.VinylNumber, vinyl.DiscSize, vinyl.RPM, vinyl.ColorVariant)
+	}
+	want := []Track{
+		{Side: "A", Order: 1, Title: "Bridge Over Troubled Water", Length: "4:52"},
+		{Side: "B", Order: 1, Title: "The Boxer", Length: "5:08"},
+	}
+	if !reflect.DeepEqual(vinyl.Tracklist, want) {
+		t.Errorf("Tracklist = %+v, want %+v", vinyl.Tracklist, want)
+	}
+
+	if _, err := provider.FetchRelease(context.Background(), "1"); !errors.Is(err, ErrReleaseNotFound) {
+		t.Errorf("unknown release: err = %v, want ErrReleaseNotFound", err)
+	}
+}
+
+func TestDiscogsSearch(t *testing.T) {
+	server := newMetadataServer(t, http.StatusNotFound, map[string]string{
+		"/database/search": discogsSearchJSON,
+	})
+	provider := &discogsProvider{baseURL: server.URL, userAgent: "VinyLibrary-test", client: server.Client()}
+
+	results, err := provider.Search(context.Background(), MetadataQuery{Title: "Bridge Over Troubled Water"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []MetadataResult{{
+		Provider:      "discogs",
+		ID:            "249504",
+		Title:         "Bridge Over Troubled Water",
+		Artist:        "Simon & Garfunkel",
+		Year:          1970,
+		Country:       "US",
+		Label:         "Columbia",
+		CatalogNumber: "KCS 9914",
+		Barcode:       "074640991414",
+		Format:        "Vinyl, LP, Album",
+		ThumbURL:      "https://img.example/249504.jpg",
+	}}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("results = %+v, want %+v", results, want)
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxReleaseJSONSize caps the size of an uploaded MusicBrainz release document
+const maxReleaseJSONSize = 10 << 20
+
+// mbArtistCredit is one entry of a MusicBrainz "artist-credit" array
+type mbArtistCredit struct {
+	Name       string `json:"name"`
+	JoinPhrase string `json:"joinphrase"`
+	Artist     struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"artist"`
+}
+
+type mbTrack struct {
+	ID           string           `json:"id"`
+	Number       string           `json:"number"` // vinyl releases use "A1", "B2", ...
+	Position     int              `json:"position"`
+	Title        string           `json:"title"`
+	Length       *int             `json:"length"` // milliseconds, may be null
+	ArtistCredit []mbArtistCredit `json:"artist-credit"`
+	Recording    struct {
+		Title  string `json:"title"`
+		Length *int   `json:"length"`
+	} `json:"recording"`
+}
+
+type mbMedium struct {
+	Position int       `json:"position"`
+	Format   string    `json:"format"`
+	Title    string    `json:"title"`
+	Tracks   []mbTrack `json:"tracks"`
+}
+
+// mbRelease is the subset of a MusicBrainz release document (ws/2 JSON with
+// inc=recordings+artist-credits+labels+release-groups) that we understand
+type mbRelease struct {
+	ID           string           `json:"id"`
+	Title        string           `json:"title"`
+	Date         string           `json:"date"`
+	Country      string           `json:"country"`
+	Barcode      string           `json:"barcode"`
+	ArtistCredit []mbArtistCredit `json:"artist-credit"`
+	LabelInfo    []struct {
+		CatalogNumber string `json:"catalog-number"`
+		Label         struct {
+			Name string `json:"name"`
+		} `json:"label"`
+	} `json:"label-info"`
+	ReleaseGroup struct {
+		PrimaryType string `json:"primary-type"`
+	} `json:"release-group"`
+	Media []mbMedium `json:"media"`
+}
+
+// mbSearchResponse is the body of a MusicBrainz /release search
+type mbSearchResponse struct {
+	Releases []mbRelease `json:"releases"`
+}
+
+// formatArtistCredit joins a MusicBrainz artist credit into a display string,
+// e.g. "Simon & Garfunkel"
+func formatArtistCredit(credits []mbArtistCredit) string {
+	var sb strings.Builder
+	for _, credit := range credits {
+		name := credit.Name
+		if name == "" {
+			name = credit.Artist.Name
+		}
+		sb.WriteString(name)
+		sb.WriteString(credit.JoinPhrase)
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// formatTrackLength converts milliseconds into the "m:ss" form used by Track.Length
+func formatTrackLength(ms *int) string {
+	if ms == nil || *ms <= 0 {
+		return ""
+	}
+	seconds := (*ms + 500) / 1000
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// splitTrackNumber splits a vinyl track number such as "A1" or "AA2" into its
+// side and order. ok is false when the number carries no side letter.
+func splitTrackNumber(number string) (side string, order int, ok bool) {
+	number = strings.TrimSpace(number)
+	i := 0
+	for i < len(number) && unicode.IsLetter(rune(number[i])) {
+		i++
+	}
+	if i == 0 {
+		return "", 0, false
+	}
+	side = strings.ToUpper(number[:i])
+	if i == len(number) {
+		// single track sides are often numbered just "A" and "B"
+		return side, 1, true
+	}
+	order, err := strconv.Atoi(number[i:])
+	if err != nil {
+		return "", 0, false
+	}
+	return side, order, true
+}
+
+// isVinylMedium reports whether a MusicBrainz medium format is a vinyl disc
+func isVinylMedium(format string) bool {
+	return strings.Contains(strings.ToLower(format), "vinyl")
+}
+
+// convertMusicBrainzRelease maps a MusicBrainz release onto a Vinyl ready to be
+// reviewed and submitted through AddVinyl. Non-vinyl media (e.g. a bundled CD)
+// are skipped unless the release has no vinyl media at all.
+func convertMusicBrainzRelease(release mbRelease) Vinyl {
+	vinyl := Vinyl{
+		Title:     strings.TrimSpace(release.Title),
+		Artist:    formatArtistCredit(release.ArtistCredit),
+		Barcode:   normalizeBarcode(release.Barcode),
+		Country:   release.Country,
+		Tracklist: []Track{},
+	}
+
+	// MusicBrainz dates may be partial ("1969" or "1969-09")
+	if len(release.Date) == len("2006-01-02") {
+		vinyl.ReleaseDate = release.Date
+	}
+
+	if len(release.LabelInfo) > 0 {
+		vinyl.Label = release.LabelInfo[0].Label.Name
+		vinyl.CatalogNumber = release.LabelInfo[0].CatalogNumber
+	}
+
+	if len(release.Date) >= 4 {
+		if year, err := strconv.Atoi(release.Date[:4]); err == nil {
+			vinyl.Year = year
+		}
+	}
+
+	switch release.ReleaseGroup.PrimaryType {
+	case "Album":
+		vinyl.VinylType = "LP"
+	case "EP":
+		vinyl.VinylType = "EP"
+	}
+
+	media := make([]mbMedium, 0, len(release.Media))
+	for _, medium := range release.Media {
+		if isVinylMedium(medium.Format) {
+			media = append(media, medium)
+		}
+	}
+	if len(media) == 0 {
+		media = release.Media
+	}
+	vinyl.VinylNumber = len(media)
+	if len(media) > 0 {
+		vinyl.DiscSize = parseDiscSize(media[0].Format)
+	}
+
+	for _, medium := range media {
+		for i, track := range medium.Tracks {
+			side, order, ok := splitTrackNumber(track.Number)
+			if !ok {
+				// no side letter, fall back to the disc number and track position
+				side = strconv.Itoa(medium.Position)
+				order = track.Position
+				if order == 0 {
+					order = i + 1
+				}
+			}
+
+			title := track.Title
+			if title == "" {
+				title = track.Recording.Title
+			}
+			length := track.Length
+			if length == nil {
+				length = track.Recording.Length
+			}
+
+			vinyl.Tracklist = append(vinyl.Tracklist, Track{
+				Side:   side,
+				Order:  order,
+				Title:  title,
+				Length: formatTrackLength(length),
+			})
+		}
+	}
+
+	return vinyl
+}
+
+// ImportMusicBrainzRelease converts an uploaded MusicBrainz release JSON document
+// into a prefilled Vinyl. Nothing is stored; the client reviews the result and
+// submits it through AddVinyl. The document is accepted either as the
+// "release" multipart file or as the raw request body.
+func ImportMusicBrainzRelease(c *gin.Context) {
+	var body io.Reader = c.Request.Body
+	if file, err := c.FormFile("release"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to open uploaded file"})
+			return
+		}
+		defer f.Close()
+		body = f
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, maxReleaseJSONSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read release document"})
+		return
+	}
+	if len(data) > maxReleaseJSONSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Release document is too large"})
+		return
+	}
+
+	var release mbRelease
+	if err := json.Unmarshal(data, &release); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid MusicBrainz release JSON"})
+		log.Println(err)
+		return
+	}
+	if release.Title == "" || len(release.Media) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Release document has no title or media"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Release converted successfully",
+		"musicbrainz_id": release.ID,
+		"vinyl":          convertMusicBrainzRelease(release),
+	})
+}
+
+// musicBrainzProvider implements MetadataProvider against the MusicBrainz web service
+type musicBrainzProvider struct {
+	baseURL   string
+	userAgent string
+	client    *http.Client
+}
+
+func (p *musicBrainzProvider) Name() string {
+	return "musicbrainz"
+}
+
+// luceneQuote quotes a term for the MusicBrainz Lucene search syntax
+func luceneQuote(term string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(term) + `"`
+}
+
+func (p *musicBrainzProvider) Search(ctx context.Context, query MetadataQuery) ([]MetadataResult, error) {
+	var terms []string
+	if query.Artist != "" {
+		terms = append(terms, "artist:"+luceneQuote(query.Artist))
+	}
+	if query.Title != "" {
+		terms = append(terms, "release:"+luceneQuote(query.Title))
+	}
+	if query.Barcode != "" {
+		terms = append(terms, "barcode:"+luceneQuote(query.Barcode))
+	}
+
+	params := url.Values{}
+	params.Set("query", strings.Join(terms, " AND "))
+	params.Set("fmt", "json")
+	params.Set("limit", "25")
+
+	var response mbSearchResponse
+	err := fetchJSON(ctx, p.client, p.baseURL+"/release?"+params.Encode(), http.Header{"User-Agent": {p.userAgent}}, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]MetadataResult, 0, len(response.Releases))
+	for _, release := range response.Releases {
+		result := MetadataResult{
+			Provider: p.Name(),
+			ID:       release.ID,
+			Title:    release.Title,
+			Artist:   formatArtistCredit(release.ArtistCredit),
+			Country:  release.Country,
+			Barcode:  release.Barcode,
+		}
+		if len(release.Date) >= 4 {
+			result.Year, _ = strconv.Atoi(release.Date[:4])
+		}
+		if len(release.LabelInfo) > 0 {
+			result.Label = release.LabelInfo[0].Label.Name
+			result.CatalogNumber = release.LabelInfo[0].CatalogNumber
+		}
+		formats := make([]string, 0, len(release.Media))
+		for _, medium := range release.Media {
+			formats = append(formats, medium.Format)
+		}
+		result.Format = strings.Join(formats, " + ")
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (p *musicBrainzProvider) FetchRelease(ctx context.Context, id string) (Vinyl, error) {
+	params := url.Values{}
+	params.Set("inc", "recordings artist-credits labels release-groups")
+	params.Set("fmt", "json")
+
+	var release mbRelease
+	err := fetchJSON(ctx, p.client, p.baseURL+"/release/"+url.PathEscape(id)+"?"+params.Encode(), http.Header{"User-Agent": {p.userAgent}}, &release)
+	// MusicBrainz answers 400 rather than 404 for ids that are not MBIDs
+	var statusErr *statusError
+	if errors.As(err, &statusErr) && statusErr.status == http.StatusBadRequest {
+		return Vinyl{}, ErrReleaseNotFound
+	}
+	if err != nil {
+		return Vinyl{}, err
+	}
+	return convertMusicBrainzRelease(release), nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// pdfDocument is a minimal PDF 1.4 writer: text, lines and JPEG images.
+// Text is set in the standard Helvetica fonts, which only cover WinAnsi
+// (Western European) characters and print anything else as "?", unless a
+// TrueType font is embedded with useFont.
+type pdfDocument struct {
+	width, height float64
+	pages         []*pdfPage
+	images        [][]byte // image XObjects, named Im1, Im2, ...
+	font          *pdfFontUse
+}
+
+type pdfPage struct {
+	doc     *pdfDocument
+	content bytes.Buffer
+}
+
+// Page sizes in points
+const (
+	pdfA4Width  = 595.0
+	pdfA4Height = 842.0
+)
+
+func newPDFDocument(width, height float64) *pdfDocument {
+	return &pdfDocument{width: width, height: height}
+}
+
+func (d *pdfDocument) addPage() *pdfPage {
+	page := &pdfPage{doc: d}
+	d.pages = append(d.pages, page)
+	return page
+}
+
+// addJPEG registers a baseline RGB JPEG and returns its resource name
+func (d *pdfDocument) addJPEG(data []byte, width, height int) string {
+	header := fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /DCTDecode /Length %d >>\nstream\n",
+		width, height, len(data))
+	obj := append([]byte(header), data...)
+	obj = append(obj, "\nendstream"...)
+	d.images = append(d.images, obj)
+	return fmt.Sprintf("Im%d", len(d.images))
+}
+
+// text draws s with its baseline starting at x, y (from the bottom left corner)
+func (p *pdfPage) text(x, y, size float64, bold bool, s string) {
+	if f := p.doc.font; f != nil {
+		// One embedded face; bold is drawn by also stroking the outlines
+		mode := "0 Tr"
+		if bold {
+			mode = fmt.Sprintf("0 G 2 Tr %.2f w", size/30)
+		}
+		fmt.Fprintf(&p.content, "q BT /F3 %.1f Tf %s %.2f %.2f Td <%s> Tj ET Q\n", size, mode, x, y, f.encode(s))
+		return
+	}
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(&p.content, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, pdfEscape(s))
+}
+
+// textRight draws s so that it ends at x
+func (p *pdfPage) textRight(x, y, size float64, bold bool, s string) {
+	p.text(x-p.doc.textWidth(s, size), y, size, bold, s)
+}
+
+// line draws a thin grey line
+func (p *pdfPage) line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(&p.content, "0.75 G 0.5 w %.2f %.2f m %.2f %.2f l S\n", x1, y1, x2, y2)
+}
+
+// image draws a registered image into the given box
+func (p *pdfPage) image(name string, x, y, w, h float64) {
+	fmt.Fprintf(&p.content, "q %.2f 0 0 %.2f %.2f %.2f cm /%s Do Q\n", w, h, x, y, name)
+}
+
+// write serialises the document
+func (d *pdfDocument) write(w io.Writer) error {
+	var objects [][]byte
+	add := func(body []byte) int {
+		objects = append(objects, body)
+		return len(objects)
+	}
+
+	catalog := add(nil)
+	pagesID := add(nil)
+	regular := add([]byte("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>"))
+	bold := add([]byte("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>"))
+
+	embedded := ""
+	if d.font != nil {
+		font, err := d.font.addObjects(add)
+		if err != nil {
+			return err
+		}
+		embedded = fmt.Sprintf(" /F3 %d 0 R", font)
+	}
+
+	var xobjects strings.Builder
+	for i, img := range d.images {
+		fmt.Fprintf(&xobjects, " /Im%d %d 0 R", i+1, add(img))
+	}
+	resources := add([]byte(fmt.Sprintf("<< /Font << /F1 %d 0 R /F2 %d 0 R%s >> /XObject <<%s >> >>", regular, bold, embedded, xobjects.String())))
+
+	var kids strings.Builder
+	for _, page := range d.pages {
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		if _, err := zw.Write(page.content.Bytes()); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		stream := []byte(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n", compressed.Len()))
+		stream = append(stream, compressed.Bytes()...)
+		stream = append(stream, "\nendstream"...)
+		content := add(stream)
+
+		pageID := add([]byte(fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.0f %.0f] /Resources %d 0 R /Contents %d 0 R >>",
+			pagesID, d.width, d.height, resources, content)))
+		fmt.Fprintf(&kids, " %d 0 R", pageID)
+	}
+	objects[pagesID-1] = []byte(fmt.Sprintf("<< /Type /Pages /Kids [%s ] /Count %d >>", kids.String(), len(d.pages)))
+	objects[catalog-1] = []byte(fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pagesID))
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objects))
+	for i, body := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n", i+1)
+		out.Write(body)
+		out.WriteString("\nendobj\n")
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, catalog, xref)
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// winAnsiSpecials maps the characters of the WinAnsi 0x80-0x9F range
+var winAnsiSpecials = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, 'ˆ': 0x88,
+	'‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E, '‘': 0x91, '’': 0x92, '“': 0x93,
+	'”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B,
+	'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+// pdfEscape encodes s as WinAnsi and escapes it for a PDF string literal
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(byte(r))
+		case r >= 0x20 && r < 0x7F, r >= 0xA0 && r <= 0xFF:
+			b.WriteByte(byte(r))
+		case winAnsiSpecials[r] != 0:
+			b.WriteByte(winAnsiSpecials[r])
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// helveticaWidths are the Helvetica glyph widths of ASCII 32-126, in 1/1000 em
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278, // space to /
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, // 0-9
+	278, 278, 584, 584, 584, 556, 1015, // : to @
+	667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, // A-M
+	722, 778, 667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, // N-Z
+	278, 278, 278, 469, 556, 333, // [ to `
+	556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, // a-m
+	556, 556, 556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, // n-z
+	334, 260, 334, 584, // { to ~
+}
+
+// textWidth estimates the width of s in the document's font, in points. Bold
+// text is slightly wider; callers leave room for that.
+func (d *pdfDocument) textWidth(s string, size float64) float64 {
+	if d.font != nil {
+		return d.font.width(s, size)
+	}
+	total := 0
+	for _, r := range s {
+		if r >= 32 && r <= 126 {
+			total += helveticaWidths[r-32]
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// truncate shortens s with an ellipsis so that it fits in width points
+func (d *pdfDocument) truncate(s string, size, width float64) string {
+	if d.textWidth(s, size) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && d.textWidth(string(runes)+"…", size) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return strings.TrimSpace(string(runes)) + "…"
+}
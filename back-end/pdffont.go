@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// pdfTrueType is a TrueType font that can be embedded in PDF documents. It
+// is shared between documents; each document tracks its glyphs in a
+// pdfFontUse.
+type pdfTrueType struct {
+	name string // PostScript name
+	data []byte
+	font *sfnt.Font
+	// Metrics in 1/1000 em, the unit of PDF glyph space
+	ascent, descent, capHeight int
+	bbox                       [4]int
+}
+
+// pdfEm requests sfnt metrics in 1/1000 em
+var pdfEm = fixed.I(1000)
+
+// loadPDFTrueType reads a TrueType font file. OpenType fonts with CFF
+// outlines and font collections are not supported.
+func loadPDFTrueType(path string) (*pdfTrueType, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !hasSFNTTable(data, "glyf") {
+		return nil, fmt.Errorf("%s has no TrueType outlines", path)
+	}
+	f, err := sfnt.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	var buf sfnt.Buffer
+	t := &pdfTrueType{data: data, font: f}
+	name, _ := f.Name(&buf, sfnt.NameIDPostScript)
+	t.name = strings.Map(func(r rune) rune {
+		if r > ' ' && r < 0x7F && !strings.ContainsRune("()<>[]{}/%#", r) {
+			return r
+		}
+		return -1
+	}, name)
+	if t.name == "" {
+		t.name = "EmbeddedFont"
+	}
+
+	metrics, err := f.Metrics(&buf, pdfEm, font.HintingNone)
+	if err != nil {
+		return nil, err
+	}
+	t.ascent, t.descent = metrics.Ascent.Round(), -metrics.Descent.Round()
+	t.capHeight = metrics.CapHeight.Round()
+	if t.capHeight == 0 {
+		t.capHeight = t.ascent
+	}
+	// sfnt measures y downwards
+	bounds, err := f.Bounds(&buf, pdfEm, font.HintingNone)
+	if err != nil {
+		return nil, err
+	}
+	t.bbox = [4]int{bounds.Min.X.Floor(), -bounds.Max.Y.Ceil(), bounds.Max.X.Ceil(), -bounds.Min.Y.Floor()}
+	return t, nil
+}
+
+// hasSFNTTable reports whether the table directory of a font file lists tag
+func hasSFNTTable(data []byte, tag string) bool {
+	if len(data) < 12 {
+		return false
+	}
+	n := int(binary.BigEndian.Uint16(data[4:6]))
+	for i := 0; i < n; i++ {
+		off := 12 + 16*i
+		if off+16 > len(data) {
+			return false
+		}
+		if string(data[off:off+4]) == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// pdfFontUse is an embedded font as used by one document
+type pdfFontUse struct {
+	*pdfTrueType
+	buf    sfnt.Buffer
+	glyphs map[sfnt.GlyphIndex]pdfGlyph
+}
+
+type pdfGlyph struct {
+	r     rune
+	width int // 1/1000 em
+}
+
+// useFont sets all the text of the document in an embedded TrueType font,
+// written as a Type0 font with Identity-H encoding so that any character the
+// font has can be printed and copied
+func (d *pdfDocument) useFont(t *pdfTrueType) {
+	d.font = &pdfFontUse{pdfTrueType: t, glyphs: map[sfnt.GlyphIndex]pdfGlyph{}}
+}
+
+// glyph returns the glyph of r, or of "?" if the font lacks it
+func (u *pdfFontUse) glyph(r rune) (sfnt.GlyphIndex, pdfGlyph) {
+	gid, err := u.font.GlyphIndex(&u.buf, r)
+	if (err != nil || gid == 0) && r != '?' {
+		return u.glyph('?')
+	}
+	if g, ok := u.glyphs[gid]; ok {
+		return gid, g
+	}
+	g := pdfGlyph{r: r}
+	if advance, err := u.font.GlyphAdvance(&u.buf, gid, pdfEm, font.HintingNone); err == nil {
+		g.width = advance.Round()
+	}
+	u.glyphs[gid] = g
+	return gid, g
+}
+
+// encode returns s as hex glyph ids for a string operand
+func (u *pdfFontUse) encode(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		gid, _ := u.glyph(r)
+		fmt.Fprintf(&b, "%04X", uint16(gid))
+	}
+	return b.String()
+}
+
+// width measures s in points
+func (u *pdfFontUse) width(s string, size float64) float64 {
+	total := 0
+	for _, r := range s {
+		_, g := u.glyph(r)
+		total += g.width
+	}
+	return float64(total) * size / 1000
+}
+
+// addObjects writes the font file, its descriptor, the CID font, the
+// ToUnicode map and the Type0 font, and returns the Type0 font's object id
+func (u *pdfFontUse) addObjects(add func([]byte) int) (int, error) {
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	if _, err := zw.Write(u.data); err != nil {
+		return 0, err
+	}
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+	file := add(pdfStream(fmt.Sprintf("/Length1 %d /Filter /FlateDecode", len(u.data)), compressed.Bytes()))
+
+	descriptor := add([]byte(fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags 32 /FontBBox [%d %d %d %d] /ItalicAngle 0 /Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 %d 0 R >>",
+		u.name, u.bbox[0], u.bbox[1], u.bbox[2], u.bbox[3], u.ascent, u.descent, u.capHeight, file)))
+
+	gids := make([]int, 0, len(u.glyphs))
+	for gid := range u.glyphs {
+		gids = append(gids, int(gid))
+	}
+	sort.Ints(gids)
+
+	var widths strings.Builder
+	for _, gid := range gids {
+		fmt.Fprintf(&widths, " %d [%d]", gid, u.glyphs[sfnt.GlyphIndex(gid)].width)
+	}
+	cidFont := add([]byte(fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> /FontDescriptor %d 0 R /CIDToGIDMap /Identity /DW 1000 /W [%s ] >>",
+		u.name, descriptor, widths.String())))
+
+	// ToUnicode lets viewers copy and search the text
+	var cmap strings.Builder
+	cmap.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n" +
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n" +
+		"/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n" +
+		"1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	for start := 0; start < len(gids); start += 100 {
+		chunk := gids[start:min(start+100, len(gids))]
+		fmt.Fprintf(&cmap, "%d beginbfchar\n", len(chunk))
+		for _, gid := range chunk {
+			fmt.Fprintf(&cmap, "<%04X> <", gid)
+			for _, unit := range utf16.Encode([]rune{u.glyphs[sfnt.GlyphIndex(gid)].r}) {
+				fmt.Fprintf(&cmap, "%04X", unit)
+			}
+			cmap.WriteString(">\n")
+		}
+		cmap.WriteString("endbfchar\n")
+	}
+	cmap.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend")
+	toUnicode := add(pdfStream("", []byte(cmap.String())))
+
+	return add([]byte(fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>",
+		u.name, cidFont, toUnicode))), nil
+}
+
+// pdfStream builds a stream object with extra dictionary entries
+func pdfStream(entries string, data []byte) []byte {
+	obj := []byte(strings.TrimSpace(fmt.Sprintf("<< /Length %d %s", len(data), entries)) + " >>\nstream\n")
+	obj = append(obj, data...)
+	return append(obj, "\nendstream"...)
+}
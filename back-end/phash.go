@@ -0,0 +1,225 @@
+package main
+
+import (
+	"image"
+	"log"
+	"math"
+	"math/bits"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Covers are compared by perceptual hash: 64 bits describing the coarse
+// structure of the image, which survive rescaling, recompression and small
+// colour shifts. The number of differing bits measures how alike two covers
+// look; a different scan of the same sleeve typically differs in a handful.
+
+// defaultDuplicateDistance is the largest Hamming distance at which two
+// covers are reported as the same artwork
+const defaultDuplicateDistance = 8
+
+// perceptualHash computes the DCT hash of an image: it is reduced to 32x32
+// grey levels, and each of the 8x8 lowest frequencies above zero sets a bit
+// when it is above their median. As in the pHash library, frequency zero is
+// left out on both axes: the DC term is the mean brightness, which would set
+// the same bit for every cover and make near-solid ones collide.
+func perceptualHash(img image.Image) uint64 {
+	const size, low = 32, 8
+	// fitImage does the heavy downscaling and flattens transparency
+	src := fitImage(img, 4*size)
+	b := src.Bounds()
+	var grey [size][size]float64
+	for y := 0; y < size; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/size, b.Min.Y+max((y+1)*b.Dy()/size, y*b.Dy()/size+1)
+		for x := 0; x < size; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/size, b.Min.X+max((x+1)*b.Dx()/size, x*b.Dx()/size+1)
+			sum, n := 0.0, 0
+			for sy := y0; sy < min(y1, b.Max.Y); sy++ {
+				for sx := x0; sx < min(x1, b.Max.X); sx++ {
+					p := src.RGBAAt(sx, sy)
+					sum += 0.299*float64(p.R) + 0.587*float64(p.G) + 0.114*float64(p.B)
+					n++
+				}
+			}
+			if n > 0 {
+				grey[y][x] = sum / float64(n)
+			}
+		}
+	}
+
+	// Frequencies 1 to low on each axis
+	var cosines [low][size]float64
+	for u := 0; u < low; u++ {
+		for x := 0; x < size; x++ {
+			cosines[u][x] = math.Cos(float64(2*x+1) * float64(u+1) * math.Pi / (2 * size))
+		}
+	}
+	var coefficients [low * low]float64
+	for v := 0; v < low; v++ {
+		for u := 0; u < low; u++ {
+			sum := 0.0
+			for y := 0; y < size; y++ {
+				for x := 0; x < size; x++ {
+					sum += grey[y][x] * cosines[u][x] * cosines[v][y]
+				}
+			}
+			coefficients[v*low+u] = sum
+		}
+	}
+
+	sorted := coefficients
+	sort.Float64s(sorted[:])
+	median := (sorted[low*low/2-1] + sorted[low*low/2]) / 2
+	var hash uint64
+	for _, c := range coefficients {
+		hash <<= 1
+		if c > median {
+			hash |= 1
+		}
+	}
+	return hash
+}
+
+// hammingDistance counts the bits in which two hashes differ
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// coverHash is the perceptual hash of a record's cover
+type coverHash struct {
+	VinylID int    `json:"vinyl_id"`
+	Title   string `json:"title"`
+	Artist  string `json:"artist"`
+	URL     string `json:"url"`
+	hash    uint64
+}
+
+// SimilarCover is an active record whose cover resembles an uploaded image
+type SimilarCover struct {
+	coverHash
+	Distance int `json:"distance"`
+}
+
+// loadCoverHashes returns the hashed primary images of active records
+func loadCoverHashes(db dbExecutor) ([]coverHash, error) {
+	rows, err := db.Query(`SELECT v.id, v.title, v.artist, v.album_picture_url, i.phash
+		FROM vinyl_images vi
+		JOIN images i ON i.hash = vi.image_hash
+		JOIN vinyls v ON v.id = vi.vinyl_id
+		WHERE vi.is_primary AND v.status = 'active' AND i.phash IS NOT NULL
+		ORDER BY v.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var covers []coverHash
+	for rows.Next() {
+		var cv coverHash
+		var hash int64
+		if err := rows.Scan(&cv.VinylID, &cv.Title, &cv.Artist, &cv.URL, &hash); err != nil {
+			return nil, err
+		}
+		cv.hash = uint64(hash)
+		covers = append(covers, cv)
+	}
+	return covers, rows.Err()
+}
+
+// similarCovers lists the records whose cover is within maxDistance of
+// hash, closest first
+func similarCovers(db dbExecutor, hash uint64, maxDistance int) ([]SimilarCover, error) {
+	covers, err := loadCoverHashes(db)
+	if err != nil {
+		return nil, err
+	}
+	similar := []SimilarCover{}
+	for _, cv := range covers {
+		if d := hammingDistance(hash, cv.hash); d <= maxDistance {
+			similar = append(similar, SimilarCover{coverHash: cv, Distance: d})
+		}
+	}
+	sort.SliceStable(similar, func(i, j int) bool { return similar[i].Distance < similar[j].Distance })
+	return similar, nil
+}
+
+// DuplicateGroup is a set of records whose covers look alike
+type DuplicateGroup struct {
+	Vinyls      []coverHash `json:"vinyls"`
+	MaxDistance int         `json:"max_distance"` // between any linked pair
+}
+
+// GetDuplicateCovers reports groups of active records whose covers are within
+// ?distance= bits (default 8, at most 32) of each other, the likely
+// duplicates of a record entered twice. Groups are linked transitively.
+func GetDuplicateCovers(c *gin.Context) {
+	maxDistance, ok, err := queryInt(c, "distance")
+	if err != nil || maxDistance < 0 || maxDistance > 32 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "distance must be between 0 and 32"})
+		return
+	}
+	if !ok {
+		maxDistance = defaultDuplicateDistance
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	covers, err := loadCoverHashes(db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve data"})
+		log.Println(err)
+		return
+	}
+
+	// Union-find over every pair close enough to be the same artwork
+	parent := make([]int, len(covers))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	type link struct{ a, distance int }
+	var links []link
+	for i := range covers {
+		for j := i + 1; j < len(covers); j++ {
+			if d := hammingDistance(covers[i].hash, covers[j].hash); d <= maxDistance {
+				parent[find(j)] = find(i)
+				links = append(links, link{i, d})
+			}
+		}
+	}
+
+	byRoot := map[int]*DuplicateGroup{}
+	var roots []int
+	for i, cv := range covers {
+		root := find(i)
+		if byRoot[root] == nil {
+			byRoot[root] = &DuplicateGroup{}
+			roots = append(roots, root)
+		}
+		byRoot[root].Vinyls = append(byRoot[root].Vinyls, cv)
+	}
+	for _, l := range links {
+		g := byRoot[find(l.a)]
+		g.MaxDistance = max(g.MaxDistance, l.distance)
+	}
+	duplicates := []DuplicateGroup{}
+	for _, root := range roots {
+		if g := byRoot[root]; len(g.Vinyls) > 1 {
+			duplicates = append(duplicates, *g)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"distance": maxDistance, "groups": duplicates})
+}
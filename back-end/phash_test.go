@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+)
+
+// sleeve draws a cover: a dark background, a disc and a diagonal band
+func sleeve(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			fx, fy := float64(x)/float64(w), float64(y)/float64(h)
+			c := color.RGBA{R: 0x20, G: 0x24, B: 0x38, A: 0xff}
+			if math.Hypot(fx-0.35, fy-0.4) < 0.25 {
+				c = color.RGBA{R: 0xe8, G: 0xc0, B: 0x30, A: 0xff}
+			} else if math.Abs(fx+fy-1.3) < 0.08 {
+				c = color.RGBA{R: 0xd0, G: 0x40, B: 0x40, A: 0xff}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// stripes draws an unrelated cover of vertical bars
+func stripes(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8(0x30)
+			if (x*7/w)%2 == 0 {
+				v = 0xe0
+			}
+			img.SetRGBA(x, y, color.RGBA{R: v, G: v, B: v, A: 0xff})
+		}
+	}
+	return img
+}
+
+// nearSolid draws a flat colour with a faint gradient running along angle
+func nearSolid(w, h int, base uint8, angle float64) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	dx, dy := math.Cos(angle), math.Sin(angle)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			t := (float64(x)/float64(w)-0.5)*dx + (float64(y)/float64(h)-0.5)*dy
+			v := uint8(float64(base) + 6*t)
+			img.SetRGBA(x, y, color.RGBA{R: v, G: v, B: v, A: 0xff})
+		}
+	}
+	return img
+}
+
+func reencodeJPEG(t *testing.T, img image.Image, quality int) image.Image {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := jpeg.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func TestPerceptualHash(t *testing.T) {
+	original := perceptualHash(sleeve(600, 600))
+	if got := bitsSet(original); got < 16 || got > 48 {
+		t.Errorf("hash %016x sets %d bits, want about half", original, got)
+	}
+
+	alike := map[string]image.Image{
+		"rescaled":   fitImage(sleeve(600, 600), 150),
+		"re-encoded": reencodeJPEG(t, sleeve(600, 600), 40),
+		"redrawn":    sleeve(500, 500),
+	}
+	for name, img := range alike {
+		if d := hammingDistance(original, perceptualHash(img)); d > defaultDuplicateDistance {
+			t.Errorf("%s copy is %d bits away, want at most %d", name, d, defaultDuplicateDistance)
+		}
+	}
+
+	if d := hammingDistance(original, perceptualHash(stripes(600, 600))); d <= 3*defaultDuplicateDistance {
+		t.Errorf("unrelated cover is only %d bits away", d)
+	}
+
+	// Plain sleeves differ only in faint gradients; the mean brightness
+	// alone must not make them match
+	dark, light := nearSolid(300, 300, 0x20, 0), nearSolid(300, 300, 0xe0, math.Pi/2)
+	if d := hammingDistance(perceptualHash(dark), perceptualHash(light)); d <= defaultDuplicateDistance {
+		t.Errorf("near-solid sleeves with different gradients are only %d bits apart", d)
+	}
+
+	// Inverting a cover negates every frequency but the mean brightness,
+	// so without it every bit flips
+	inverted := sleeve(600, 600)
+	for i := range inverted.Pix {
+		if i%4 != 3 {
+			inverted.Pix[i] = 0xff - inverted.Pix[i]
+		}
+	}
+	if d := hammingDistance(original, perceptualHash(inverted)); d != 64 {
+		t.Errorf("inverted cover is %d bits away, want all 64", d)
+	}
+}
+
+func bitsSet(h uint64) int {
+	return hammingDistance(h, 0)
+}
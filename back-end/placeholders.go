@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Covers are summarised for display while they load: a palette of their
+// dominant colours and a blurhash (https://blurha.sh), a short string that
+// clients decode into a blurred preview.
+
+const (
+	paletteSize        = 5
+	placeholderSide    = 64 // covers are scaled down to this before analysis
+	blurhashComponents = 4  // per axis; covers are close to square
+)
+
+// imagePlaceholders returns the palette and blurhash of a cover
+func imagePlaceholders(img image.Image) ([]string, string) {
+	small := fitImage(img, placeholderSide)
+	return imagePalette(small, paletteSize), encodeBlurhash(small, blurhashComponents, blurhashComponents)
+}
+
+// colorBox is a set of pixels in median-cut quantisation
+type colorBox struct {
+	pixels [][3]uint8
+}
+
+// widest returns the channel with the largest range and that range
+func (b colorBox) widest() (int, int) {
+	channel, spread := 0, -1
+	for c := 0; c < 3; c++ {
+		lo, hi := 255, 0
+		for _, p := range b.pixels {
+			lo, hi = min(lo, int(p[c])), max(hi, int(p[c]))
+		}
+		if hi-lo > spread {
+			channel, spread = c, hi-lo
+		}
+	}
+	return channel, spread
+}
+
+func (b colorBox) average() string {
+	var sum [3]int
+	for _, p := range b.pixels {
+		for c := 0; c < 3; c++ {
+			sum[c] += int(p[c])
+		}
+	}
+	n := len(b.pixels)
+	return fmt.Sprintf("#%02x%02x%02x", (sum[0]+n/2)/n, (sum[1]+n/2)/n, (sum[2]+n/2)/n)
+}
+
+// imagePalette finds up to n dominant colours by median cut: the box of
+// pixels whose colours vary most is split near its median until there are n
+// boxes. Colours are "#rrggbb", most common first.
+func imagePalette(img *image.RGBA, n int) []string {
+	bounds := img.Bounds()
+	pixels := make([][3]uint8, 0, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			p := img.RGBAAt(x, y)
+			pixels = append(pixels, [3]uint8{p.R, p.G, p.B})
+		}
+	}
+	if len(pixels) == 0 {
+		return []string{}
+	}
+
+	boxes := []colorBox{{pixels: pixels}}
+	for len(boxes) < n {
+		// Split the box with the most colour variation weighted by size
+		best, bestScore, bestChannel := -1, 0, 0
+		for i, b := range boxes {
+			channel, spread := b.widest()
+			if score := spread * len(b.pixels); len(b.pixels) > 1 && spread > 0 && score > bestScore {
+				best, bestScore, bestChannel = i, score, channel
+			}
+		}
+		if best < 0 {
+			break
+		}
+		box := boxes[best].pixels
+		sort.Slice(box, func(i, j int) bool { return box[i][bestChannel] < box[j][bestChannel] })
+		// Cut at the change of value nearest the median, so that pixels of
+		// one colour stay together rather than being averaged with another
+		mid := len(box) / 2
+		for lo, hi := mid, mid; ; lo, hi = lo-1, hi+1 {
+			if lo > 0 && box[lo-1][bestChannel] != box[lo][bestChannel] {
+				mid = lo
+				break
+			}
+			if hi < len(box) && box[hi-1][bestChannel] != box[hi][bestChannel] {
+				mid = hi
+				break
+			}
+		}
+		boxes[best] = colorBox{pixels: box[:mid]}
+		boxes = append(boxes, colorBox{pixels: box[mid:]})
+	}
+
+	sort.SliceStable(boxes, func(i, j int) bool { return len(boxes[i].pixels) > len(boxes[j].pixels) })
+	palette := make([]string, 0, len(boxes))
+	seen := map[string]bool{}
+	for _, b := range boxes {
+		if c := b.average(); !seen[c] {
+			seen[c] = true
+			palette = append(palette, c)
+		}
+	}
+	return palette
+}
+
+const blurhashDigits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// encodeBlurhash encodes an image with xComponents by yComponents cosine
+// components, each between 1 and 9
+func encodeBlurhash(img *image.RGBA, xComponents, yComponents int) string {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w == 0 || h == 0 {
+		return ""
+	}
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			var f [3]float64
+			for y := 0; y < h; y++ {
+				cy := math.Cos(math.Pi * float64(j) * float64(y) / float64(h))
+				for x := 0; x < w; x++ {
+					basis := normalisation * math.Cos(math.Pi*float64(i)*float64(x)/float64(w)) * cy
+					p := img.RGBAAt(bounds.Min.X+x, bounds.Min.Y+y)
+					f[0] += basis * srgbToLinear(p.R)
+					f[1] += basis * srgbToLinear(p.G)
+					f[2] += basis * srgbToLinear(p.B)
+				}
+			}
+			scale := 1 / float64(w*h)
+			factors = append(factors, [3]float64{f[0] * scale, f[1] * scale, f[2] * scale})
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(encodeBase83((xComponents-1)+(yComponents-1)*9, 1))
+
+	dc, ac := factors[0], factors[1:]
+	maximum := 1.0
+	if len(ac) > 0 {
+		actual := 0.0
+		for _, f := range ac {
+			actual = math.Max(actual, math.Max(math.Abs(f[0]), math.Max(math.Abs(f[1]), math.Abs(f[2]))))
+		}
+		quantised := int(math.Max(0, math.Min(82, math.Floor(actual*166-0.5))))
+		maximum = float64(quantised+1) / 166
+		hash.WriteString(encodeBase83(quantised, 1))
+	} else {
+		hash.WriteString(encodeBase83(0, 1))
+	}
+
+	hash.WriteString(encodeBase83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4))
+	for _, f := range ac {
+		quantise := func(v float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(v/maximum, 0.5)*9+9.5))))
+		}
+		hash.WriteString(encodeBase83(quantise(f[0])*19*19+quantise(f[1])*19+quantise(f[2]), 2))
+	}
+	return hash.String()
+}
+
+func encodeBase83(value, length int) string {
+	digits := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		digits[i] = blurhashDigits[value%83]
+		value /= 83
+	}
+	return string(digits)
+}
+
+func srgbToLinear(c uint8) float64 {
+	v := float64(c) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
+
+// placeholderBatchSize is how many images a backfill batch processes unless
+// told otherwise
+const placeholderBatchSize = 100
+
+// placeholderBatch is the outcome of one backfill batch. Next is the cursor
+// to resume from, empty once every image has been seen.
+type placeholderBatch struct {
+	Updated int     `json:"updated"`
+	Skipped []gin.H `json:"skipped"`
+	Next    string  `json:"next"`
+}
+
+// backfillImagePlaceholders computes the palette, blurhash and perceptual
+// hash of up to limit images whose hash sorts after the cursor. Unless all
+// is set, images that already have them are passed over. Images whose file
+// is missing or cannot be decoded are listed and left unchanged.
+func backfillImagePlaceholders(ctx context.Context, db dbExecutor, after string, limit int, all bool) (placeholderBatch, error) {
+	batch := placeholderBatch{Skipped: []gin.H{}}
+	query := "SELECT hash, ext FROM images WHERE hash > $1 AND (blurhash = '' OR phash IS NULL) ORDER BY hash LIMIT $2"
+	if all {
+		query = "SELECT hash, ext FROM images WHERE hash > $1 ORDER BY hash LIMIT $2"
+	}
+	rows, err := db.Query(query, after, limit)
+	if err != nil {
+		return batch, err
+	}
+	type pending struct{ hash, ext string }
+	var images []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.hash, &p.ext); err != nil {
+			rows.Close()
+			return batch, err
+		}
+		images = append(images, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return batch, err
+	}
+
+	for _, p := range images {
+		img, err := decodeAlbumImage(ctx, albumKey(imageFilename(p.hash, p.ext)))
+		if err != nil {
+			batch.Skipped = append(batch.Skipped, gin.H{"hash": p.hash, "error": err.Error()})
+			continue
+		}
+		palette, blurhash := imagePlaceholders(img)
+		if _, err := db.Exec("UPDATE images SET palette = $1, blurhash = $2, phash = $3 WHERE hash = $4",
+			pq.Array(palette), blurhash, int64(perceptualHash(img)), p.hash); err != nil {
+			return batch, fmt.Errorf("updating image %s: %w", p.hash, err)
+		}
+		batch.Updated++
+	}
+	if len(images) == limit {
+		batch.Next = images[len(images)-1].hash
+	}
+	return batch, nil
+}
+
+// BackfillImagePlaceholders computes the palette, blurhash and perceptual
+// hash of stored images that lack them, such as those uploaded before they
+// existed, one batch of ?limit= images (default 100) per request. Pass the
+// returned next as ?after= to continue; next is empty once done. ?all=true
+// recomputes every image. For large stores prefer the backfill-placeholders
+// command, which runs every batch.
+func BackfillImagePlaceholders(c *gin.Context) {
+	all, _, err := queryBool(c, "all")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	limit, ok, err := queryInt(c, "limit")
+	if err != nil || (ok && limit <= 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+		return
+	}
+	if !ok {
+		limit = placeholderBatchSize
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	batch, err := backfillImagePlaceholders(c.Request.Context(), db, c.Query("after"), limit, all)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update images"})
+		log.Println(err)
+		return
+	}
+	c.JSON(http.StatusOK, batch)
+}
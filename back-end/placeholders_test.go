@@ -0,0 +1,82 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+// blurhashTestImage is a 32x24 gradient; the expected hashes below are what
+// the reference encoder (github.com/buckket/go-blurhash, a port of the one at
+// https://github.com/woltapp/blurhash) produces for it
+func blurhashTestImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 32, 24))
+	for y := 0; y < 24; y++ {
+		for x := 0; x < 32; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: uint8(x * 8), G: uint8(y * 10), B: uint8(255 - x*4 - y*3), A: 0xff})
+		}
+	}
+	return img
+}
+
+func TestEncodeBlurhash(t *testing.T) {
+	tests := []struct {
+		x, y int
+		want string
+	}{
+		{1, 1, "00H281"},
+		{4, 3, "LxH2812yw#XAmLWZjuf8gLfkfQfk"},
+		{4, 4, "UxH2812yw#XAmLWZjuf8gLfkfQfkn-WrjufR"},
+	}
+	img := blurhashTestImage()
+	for _, tt := range tests {
+		if got := encodeBlurhash(img, tt.x, tt.y); got != tt.want {
+			t.Errorf("encodeBlurhash(%dx%d) = %q, want %q", tt.x, tt.y, got, tt.want)
+		}
+	}
+
+	// Only the pixels inside the bounds count
+	sub := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	for y := 0; y < 30; y++ {
+		for x := 0; x < 40; x++ {
+			sub.SetRGBA(x, y, color.RGBA{R: 0xff, A: 0xff})
+		}
+	}
+	inner := sub.SubImage(image.Rect(4, 3, 36, 27)).(*image.RGBA)
+	for y := 0; y < 24; y++ {
+		for x := 0; x < 32; x++ {
+			inner.SetRGBA(4+x, 3+y, img.RGBAAt(x, y))
+		}
+	}
+	if got := encodeBlurhash(inner, 4, 3); got != tests[1].want {
+		t.Errorf("encodeBlurhash of a sub-image = %q, want %q", got, tests[1].want)
+	}
+}
+
+func TestImagePalette(t *testing.T) {
+	// Half navy, a third orange, the rest white
+	img := image.NewRGBA(image.Rect(0, 0, 12, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 12; x++ {
+			c := color.RGBA{R: 0x1b, G: 0x2a, B: 0x49, A: 0xff}
+			switch {
+			case x >= 10:
+				c = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+			case x >= 6:
+				c = color.RGBA{R: 0xf2, G: 0x8c, B: 0x28, A: 0xff}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	want := []string{"#1b2a49", "#f28c28", "#ffffff"}
+	if got := imagePalette(img, paletteSize); !reflect.DeepEqual(got, want) {
+		t.Errorf("imagePalette = %v, want %v", got, want)
+	}
+	if got := imagePalette(img, 1); len(got) != 1 {
+		t.Errorf("imagePalette(n=1) = %v, want one colour", got)
+	}
+	if got := imagePalette(image.NewRGBA(image.Rect(0, 0, 0, 0)), paletteSize); len(got) != 0 {
+		t.Errorf("imagePalette of an empty image = %v", got)
+	}
+}
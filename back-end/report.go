@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"image/jpeg"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// insuranceLine is one record of the insurance inventory
+type insuranceLine struct {
+	MarketValue
+	Year       int
+	PictureURL string
+	Valued     bool
+}
+
+// insuranceLines loads the active vinyls matching the filter with their
+// latest estimate, if any, ordered by artist and title
+func insuranceLines(db dbExecutor, filter *vinylFilter) ([]insuranceLine, error) {
+	rows, err := db.Query(`SELECT v.id, v.title, v.artist, COALESCE(v.year, 0), v.media_condition, v.sleeve_condition,
+			COALESCE(v.price, 0), COALESCE(v.currency, ''), COALESCE(v.album_picture_url, ''),
+			o.value, o.currency, o.observed_at, o.source
+		FROM (SELECT id, title, artist, year, media_condition, sleeve_condition, price, currency, album_picture_url FROM vinyls`+filter.where()+`) v
+		LEFT JOIN (`+latestObservationsQuery+`) o (vinyl_id, observed_at, value, currency, source) ON o.vinyl_id = v.id
+		ORDER BY LOWER(v.artist), LOWER(v.title), v.id`, filter.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := []insuranceLine{}
+	for rows.Next() {
+		var l insuranceLine
+		var value sql.NullFloat64
+		var currency, observedAt, source sql.NullString
+		if err := rows.Scan(&l.VinylID, &l.Title, &l.Artist, &l.Year, &l.MediaCondition, &l.SleeveCondition,
+			&l.Price, &l.PriceCurrency, &l.PictureURL, &value, &currency, &observedAt, &source); err != nil {
+			return nil, err
+		}
+		if value.Valid {
+			l.Valued = true
+			l.Value, l.ValueCurrency = value.Float64, currency.String
+			l.ObservedAt, l.Source = observedAt.String, source.String
+		}
+		lines = append(lines, l)
+	}
+	return lines, rows.Err()
+}
+
+// coverThumbnail is an album picture embedded in the report
+type coverThumbnail struct {
+	name          string
+	width, height int
+}
+
+// addCoverThumbnail embeds the album picture of a vinyl as a small JPEG. The
+// name is empty if the picture is missing or unreadable.
+func addCoverThumbnail(ctx context.Context, doc *pdfDocument, pictureURL string, cache map[string]coverThumbnail) coverThumbnail {
+	key, ok := albumPictureKey(pictureURL)
+	if !ok {
+		return coverThumbnail{}
+	}
+	if thumb, ok := cache[key]; ok {
+		return thumb
+	}
+	cache[key] = coverThumbnail{}
+
+	src, err := decodeAlbumImage(ctx, key)
+	if err != nil {
+		log.Println(err)
+		return coverThumbnail{}
+	}
+	// 3 pixels per point keeps the covers sharp when printed
+	img := fitImage(src, 108)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80}); err != nil {
+		log.Println(err)
+		return coverThumbnail{}
+	}
+	thumb := coverThumbnail{width: img.Bounds().Dx(), height: img.Bounds().Dy()}
+	thumb.name = doc.addJPEG(buf.Bytes(), thumb.width, thumb.height)
+	cache[key] = thumb
+	return thumb
+}
+
+func formatMoney(amount float64, currency string) string {
+	return strings.TrimSpace(fmt.Sprintf("%.2f %s", amount, currency))
+}
+
+// formatAmounts lists amounts per currency, sorted by currency
+func formatAmounts(amounts map[string]float64) string {
+	currencies := make([]string, 0, len(amounts))
+	for currency := range amounts {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	parts := make([]string, len(currencies))
+	for i, currency := range currencies {
+		parts[i] = formatMoney(amounts[currency], orDash(currency))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Layout of the insurance inventory, in points
+const (
+	reportMargin    = 36.0
+	reportRowHeight = 44.0
+	reportThumbSize = 36.0
+	reportTitleX    = 80.0
+	reportYearX     = 300.0
+	reportGradeX    = 334.0
+	reportPriceEnd  = 470.0
+	reportValueEnd  = pdfA4Width - reportMargin
+)
+
+// drawReportHeader draws the column headings of a page and returns the y of the first row
+func drawReportHeader(page *pdfPage, y float64) float64 {
+	page.text(reportTitleX, y, 8, true, "Title / Artist")
+	page.text(reportYearX, y, 8, true, "Year")
+	page.text(reportGradeX, y, 8, true, "Media / Sleeve")
+	page.textRight(reportPriceEnd, y, 8, true, "Purchase price")
+	page.textRight(reportValueEnd, y, 8, true, "Estimated value")
+	page.line(reportMargin, y-5, reportValueEnd, y-5)
+	return y - 10
+}
+
+// The report font is read from REPORT_FONT_FILE once, on first use
+var (
+	reportFontOnce sync.Once
+	reportFont     *pdfTrueType
+)
+
+// loadReportFont returns the TrueType font set by REPORT_FONT_FILE, or nil to
+// use Helvetica when it is unset or cannot be loaded
+func loadReportFont() *pdfTrueType {
+	reportFontOnce.Do(func() {
+		path := os.Getenv("REPORT_FONT_FILE")
+		if path == "" {
+			return
+		}
+		f, err := loadPDFTrueType(path)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		reportFont = f
+	})
+	return reportFont
+}
+
+// buildInsuranceReport lays out the inventory and its totals in target currency
+func buildInsuranceReport(ctx context.Context, lines []insuranceLine, rates ExchangeRates, target string, generated time.Time) *pdfDocument {
+	doc := newPDFDocument(pdfA4Width, pdfA4Height)
+	if f := loadReportFont(); f != nil {
+		doc.useFont(f)
+	}
+	thumbs := map[string]coverThumbnail{}
+
+	var pages []*pdfPage
+	newPage := func() (*pdfPage, float64) {
+		page := doc.addPage()
+		pages = append(pages, page)
+		y := pdfA4Height - reportMargin - 10
+		if len(pages) == 1 {
+			page.text(reportMargin, y, 16, true, "Vinyl collection inventory")
+			y -= 18
+			page.text(reportMargin, y, 9, false, fmt.Sprintf("Generated %s - %d records - totals in %s", generated.Format("2006-01-02 15:04"), len(lines), target))
+			y -= 24
+		}
+		return page, drawReportHeader(page, y)
+	}
+
+	page, y := newPage()
+	var purchaseTotal, valueTotal, replacementTotal float64
+	// Amounts without an exchange rate, kept apart since cost and value do not add up
+	unconvertedPurchase, unconvertedValue := map[string]float64{}, map[string]float64{}
+	unvalued := 0
+	for _, l := range lines {
+		if y-reportRowHeight < reportMargin+20 {
+			page, y = newPage()
+		}
+		top := y
+		y -= reportRowHeight
+
+		if thumb := addCoverThumbnail(ctx, doc, l.PictureURL, thumbs); thumb.name != "" {
+			// Fit into the square, centred
+			w, h := reportThumbSize, reportThumbSize
+			if thumb.width > thumb.height {
+				h = w * float64(thumb.height) / float64(thumb.width)
+			} else {
+				w = h * float64(thumb.width) / float64(thumb.height)
+			}
+			page.image(thumb.name, reportMargin+(reportThumbSize-w)/2, y+4+(reportThumbSize-h)/2, w, h)
+		} else {
+			x0, y0, x1, y1 := reportMargin, y+4, reportMargin+reportThumbSize, y+4+reportThumbSize
+			page.line(x0, y0, x1, y0)
+			page.line(x1, y0, x1, y1)
+			page.line(x1, y1, x0, y1)
+			page.line(x0, y1, x0, y0)
+		}
+
+		page.text(reportTitleX, top-14, 9, true, doc.truncate(l.Title, 9, reportYearX-reportTitleX-12))
+		page.text(reportTitleX, top-26, 8, false, doc.truncate(l.Artist, 8, reportYearX-reportTitleX-8))
+		if l.Year > 0 {
+			page.text(reportYearX, top-14, 9, false, fmt.Sprint(l.Year))
+		}
+		grades := "-"
+		if l.MediaCondition != "" || l.SleeveCondition != "" {
+			grades = fmt.Sprintf("%s / %s", orDash(l.MediaCondition), orDash(l.SleeveCondition))
+		}
+		page.text(reportGradeX, top-14, 9, false, grades)
+		page.textRight(reportPriceEnd, top-14, 9, false, formatMoney(l.Price, l.PriceCurrency))
+
+		l.computeGainLoss(rates, target)
+		if l.PriceConverted != nil {
+			purchaseTotal += *l.PriceConverted
+		} else {
+			unconvertedPurchase[l.PriceCurrency] = roundMoney(unconvertedPurchase[l.PriceCurrency] + l.Price)
+		}
+		if l.Valued {
+			page.textRight(reportValueEnd, top-14, 9, false, formatMoney(l.Value, l.ValueCurrency))
+			page.textRight(reportValueEnd, top-26, 7, false, doc.truncate(strings.TrimSpace(l.ObservedAt+" "+l.Source), 7, 110))
+			if l.ValueConverted != nil {
+				valueTotal += *l.ValueConverted
+				replacementTotal += *l.ValueConverted
+			} else {
+				unconvertedValue[l.ValueCurrency] = roundMoney(unconvertedValue[l.ValueCurrency] + l.Value)
+			}
+		} else {
+			unvalued++
+			page.textRight(reportValueEnd, top-14, 9, false, "not estimated")
+			if l.PriceConverted != nil {
+				replacementTotal += *l.PriceConverted
+			}
+		}
+		page.line(reportMargin, y, reportValueEnd, y)
+	}
+
+	// Totals block
+	totals := []string{
+		fmt.Sprintf("Records: %d (%d without estimate)", len(lines), unvalued),
+		fmt.Sprintf("Purchase total: %s", formatMoney(roundMoney(purchaseTotal), target)),
+		fmt.Sprintf("Estimated value: %s", formatMoney(roundMoney(valueTotal), target)),
+		fmt.Sprintf("Replacement value (estimate, else purchase price): %s", formatMoney(roundMoney(replacementTotal), target)),
+	}
+	if len(unconvertedPurchase) > 0 {
+		totals = append(totals, "Purchases not included, no exchange rate: "+formatAmounts(unconvertedPurchase))
+	}
+	if len(unconvertedValue) > 0 {
+		totals = append(totals, "Estimates not included, no exchange rate: "+formatAmounts(unconvertedValue))
+	}
+	if rates.Date != "" {
+		totals = append(totals, "Exchange rates as of "+rates.Date)
+	}
+	if y-float64(len(totals))*14-20 < reportMargin+20 {
+		page, y = newPage()
+	}
+	y -= 20
+	for i, line := range totals {
+		page.text(reportMargin, y, 10, i < 4, line)
+		y -= 14
+	}
+
+	for i, p := range pages {
+		p.textRight(reportValueEnd, reportMargin-10, 8, false, fmt.Sprintf("Page %d of %d", i+1, len(pages)))
+	}
+	return doc
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// GetInsuranceReport exports the active collection as a printable PDF
+// inventory with cover thumbnails, grades, purchase prices, latest estimates
+// and totals. It accepts the vinyl list filters and ?currency=. Text is set
+// in Helvetica, which only covers Western European characters and prints
+// others, such as CJK titles, as "?"; point REPORT_FONT_FILE at a TrueType
+// font covering them to embed it instead.
+func GetInsuranceReport(c *gin.Context) {
+	rates, err := loadExchangeRates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load exchange rates"})
+		log.Println(err)
+		return
+	}
+	target, ok := valuationCurrency(c, rates)
+	if !ok {
+		return
+	}
+
+	filter, err := parseVinylFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	lines, err := insuranceLines(db, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve data"})
+		log.Println(err)
+		return
+	}
+
+	generated := time.Now()
+	var buf bytes.Buffer
+	if err := buildInsuranceReport(c.Request.Context(), lines, rates, target, generated).write(&buf); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate report"})
+		log.Println(err)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="vinyl-inventory-%s.pdf"`, generated.Format("2006-01-02")))
+	c.Data(http.StatusOK, "application/pdf", buf.Bytes())
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3Config configures an S3-compatible store such as AWS S3 or MinIO
+type s3Config struct {
+	Endpoint      string // e.g. https://s3.eu-west-1.amazonaws.com or http://localhost:9000
+	Region        string
+	Bucket        string
+	Prefix        string // prepended to every key
+	AccessKey     string
+	SecretKey     string
+	PathStyle     bool // address the bucket as /<bucket>/<key>, as MinIO expects
+	PresignReads  bool // redirect reads to presigned URLs instead of proxying them
+	PresignExpiry time.Duration
+}
+
+// s3BlobStore talks to an S3-compatible API directly, signing requests with
+// AWS Signature Version 4
+type s3BlobStore struct {
+	cfg      s3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+const s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+func newS3BlobStore(cfg s3Config) (*s3BlobStore, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("S3_ENDPOINT and S3_BUCKET are required for the s3 storage backend")
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY are required for the s3 storage backend")
+	}
+	endpoint, err := url.Parse(strings.TrimRight(cfg.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("invalid S3_ENDPOINT %q", cfg.Endpoint)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Prefix != "" {
+		cfg.Prefix = strings.Trim(cfg.Prefix, "/") + "/"
+	}
+	return &s3BlobStore{cfg: cfg, endpoint: endpoint, client: &http.Client{Timeout: 60 * time.Second}}, nil
+}
+
+// objectURL is the URL of an object, or of the bucket when key is empty
+func (s *s3BlobStore) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	path := "/" + key
+	if s.cfg.PathStyle {
+		path = "/" + s.cfg.Bucket + path
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+	}
+	u.Path = path
+	u.RawPath = s3EscapePath(path)
+	return &u
+}
+
+func (s *s3BlobStore) objectKey(key string) (string, error) {
+	key, err := cleanBlobKey(key)
+	if err != nil {
+		return "", err
+	}
+	return s.cfg.Prefix + key, nil
+}
+
+// do signs and sends a request. body may be nil.
+func (s *s3BlobStore) do(ctx context.Context, method string, u *url.URL, body []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	sum := sha256.Sum256(body)
+	s.sign(req, hex.EncodeToString(sum[:]), time.Now())
+	return s.client.Do(req)
+}
+
+// s3Error turns an unexpected response into an error, consuming its body
+func s3Error(resp *http.Response, method, key string) error {
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errBlobNotFound
+	}
+	var e struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+		return fmt.Errorf("s3 %s %s: %s: %s", method, key, e.Code, e.Message)
+	}
+	return fmt.Errorf("s3 %s %s: %s", method, key, resp.Status)
+}
+
+func (s *s3BlobStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	objKey, err := s.objectKey(key)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(ctx, http.MethodPut, s.objectURL(objKey), data, header)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp, "PUT", key)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3BlobStore) Get(ctx context.Context, key string) (io.ReadCloser, BlobInfo, error) {
+	objKey, err := s.objectKey(key)
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+	resp, err := s.do(ctx, http.MethodGet, s.objectURL(objKey), nil, nil)
+	if err != nil {
+		return nil, BlobInfo{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, BlobInfo{}, s3Error(resp, "GET", key)
+	}
+	return resp.Body, s3BlobInfo(key, resp), nil
+}
+
+func (s *s3BlobStore) Stat(ctx context.Context, key string) (BlobInfo, error) {
+	objKey, err := s.objectKey(key)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	resp, err := s.do(ctx, http.MethodHead, s.objectURL(objKey), nil, nil)
+	if err != nil {
+		return BlobInfo{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return BlobInfo{}, s3Error(resp, "HEAD", key)
+	}
+	resp.Body.Close()
+	return s3BlobInfo(key, resp), nil
+}
+
+func (s *s3BlobStore) Delete(ctx context.Context, key string) error {
+	objKey, err := s.objectKey(key)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, s.objectURL(objKey), nil, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		if err := s3Error(resp, "DELETE", key); err != errBlobNotFound {
+			return err
+		}
+		return nil
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Rename copies the object server-side and deletes the original, since S3
+// has no move operation
+func (s *s3BlobStore) Rename(ctx context.Context, from, to string) error {
+	src, err := s.objectKey(from)
+	if err != nil {
+		return err
+	}
+	dst, err := s.objectKey(to)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("X-Amz-Copy-Source", s3EscapePath("/"+s.cfg.Bucket+"/"+src))
+	resp, err := s.do(ctx, http.MethodPut, s.objectURL(dst), nil, header)
+	if err != nil {
+		return err
+	}
+	// A copy can fail after the 200 status is sent; the body then holds an error
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+		return s3Error(resp, "COPY", from)
+	}
+	if bytes.Contains(data, []byte("<Error>")) {
+		return fmt.Errorf("s3 COPY %s: %s", from, data)
+	}
+	return s.Delete(ctx, from)
+}
+
+func (s *s3BlobStore) List(ctx context.Context, prefix string, fn func(BlobInfo) error) error {
+	token := ""
+	for {
+		u := s.objectURL("")
+		q := url.Values{}
+		q.Set("list-type", "2")
+		q.Set("prefix", s.cfg.Prefix+prefix)
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		u.RawQuery = s3CanonicalQuery(q)
+		resp, err := s.do(ctx, http.MethodGet, u, nil, nil)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return s3Error(resp, "LIST", prefix)
+		}
+		var result struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				LastModified time.Time `xml:"LastModified"`
+				ETag         string    `xml:"ETag"`
+				Size         int64     `xml:"Size"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		for _, obj := range result.Contents {
+			info := BlobInfo{
+				Key:     strings.TrimPrefix(obj.Key, s.cfg.Prefix),
+				Size:    obj.Size,
+				ModTime: obj.LastModified,
+				ETag:    strings.Trim(obj.ETag, `"`),
+			}
+			if err := fn(info); err != nil {
+				return err
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+func (s *s3BlobStore) PresignGet(key string, expiry time.Duration) (string, bool) {
+	if !s.cfg.PresignReads {
+		return "", false
+	}
+	objKey, err := s.objectKey(key)
+	if err != nil {
+		return "", false
+	}
+	if expiry <= 0 {
+		expiry = s.cfg.PresignExpiry
+	}
+	// Signing at the start of the current half-expiry window keeps the URL
+	// stable for a while, so browsers can cache what it points to. It stays
+	// valid for at least half the expiry.
+	return s.presign(s.objectURL(objKey), expiry, time.Now().Truncate(expiry/2)), true
+}
+
+func s3BlobInfo(key string, resp *http.Response) BlobInfo {
+	info := BlobInfo{Key: key, Size: resp.ContentLength, ETag: strings.Trim(resp.Header.Get("ETag"), `"`)}
+	if size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64); err == nil {
+		info.Size = size
+	}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = t
+	}
+	return info
+}
+
+// sign adds SigV4 authorization to a request. Host, Content-Type and the
+// x-amz-* headers are signed.
+func (s *s3BlobStore) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope, signature := s.signature(canonical, now)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, signedHeaders, signature))
+}
+
+// presign returns u with a query-string signature valid for expiry
+func (s *s3BlobStore) presign(u *url.URL, expiry time.Duration, now time.Time) string {
+	signed := *u
+	q := signed.Query()
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", s.cfg.AccessKey+"/"+s.scope(now))
+	q.Set("X-Amz-Date", now.UTC().Format("20060102T150405Z"))
+	q.Set("X-Amz-Expires", strconv.Itoa(int(expiry/time.Second)))
+	q.Set("X-Amz-SignedHeaders", "host")
+	query := s3CanonicalQuery(q)
+
+	canonical := strings.Join([]string{
+		http.MethodGet,
+		signed.EscapedPath(),
+		query,
+		"host:" + signed.Host + "\n",
+		"host",
+		s3UnsignedPayload,
+	}, "\n")
+	_, signature := s.signature(canonical, now)
+	signed.RawQuery = query + "&X-Amz-Signature=" + signature
+	return signed.String()
+}
+
+func (s *s3BlobStore) scope(now time.Time) string {
+	return now.UTC().Format("20060102") + "/" + s.cfg.Region + "/s3/aws4_request"
+}
+
+// signature signs a canonical request, returning the credential scope and
+// the hex signature
+func (s *s3BlobStore) signature(canonical string, now time.Time) (string, string) {
+	scope := s.scope(now)
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + now.UTC().Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), now.UTC().Format("20060102"))
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return scope, hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape percent-encodes everything but the unreserved characters, as
+// SigV4 requires; url.QueryEscape differs on spaces and '~'
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (keepSlash && c == '/') {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3EscapePath(path string) string {
+	return s3Escape(path, true)
+}
+
+// s3CanonicalQuery encodes a query sorted by name, as SigV4 requires
+func s3CanonicalQuery(q url.Values) string {
+	names := make([]string, 0, len(q))
+	for name := range q {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var parts []string
+	for _, name := range names {
+		values := append([]string(nil), q[name]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, s3Escape(name, false)+"="+s3Escape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
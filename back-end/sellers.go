@@ -0,0 +1,333 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sellerKinds lists the kinds of places records are bought from
+var sellerKinds = map[string]bool{"shop": true, "online": true, "market": true, "person": true, "other": true}
+
+// Seller is a shop, website or person records are bought from
+type Seller struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	Kind       string `json:"kind"`
+	URL        string `json:"url"`
+	Notes      string `json:"notes"`
+	VinylCount int    `json:"vinyl_count"`
+}
+
+// validateAcquisition normalises the acquisition details of a vinyl
+func validateAcquisition(v *Vinyl) error {
+	v.AcquisitionState = strings.ToLower(strings.TrimSpace(v.AcquisitionState))
+	if v.AcquisitionState != "" && v.AcquisitionState != "new" && v.AcquisitionState != "used" {
+		return fmt.Errorf("acquisition_state must be new or used")
+	}
+	if v.ShippingCost < 0 {
+		return fmt.Errorf("shipping_cost cannot be negative")
+	}
+	v.OrderID = strings.TrimSpace(v.OrderID)
+	v.GiftFrom = strings.TrimSpace(v.GiftFrom)
+	return nil
+}
+
+// validateSeller checks and normalises a seller before it is stored
+func validateSeller(s *Seller) error {
+	s.Name = strings.TrimSpace(s.Name)
+	if s.Name == "" {
+		return fmt.Errorf("seller name cannot be empty")
+	}
+	s.Kind = strings.ToLower(strings.TrimSpace(s.Kind))
+	if s.Kind == "" {
+		s.Kind = "shop"
+	}
+	if !sellerKinds[s.Kind] {
+		return fmt.Errorf("kind must be shop, online, market, person or other")
+	}
+	s.URL = strings.TrimSpace(s.URL)
+	s.Notes = strings.TrimSpace(s.Notes)
+	return nil
+}
+
+// GetSellers lists sellers by name, optionally matching ?q=
+func GetSellers(c *gin.Context) {
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT s.id, s.name, s.kind, s.url, s.notes,
+			(SELECT COUNT(*) FROM vinyls v WHERE v.seller_id = s.id AND v.status = 'active')
+		FROM sellers s
+		WHERE $1 = '' OR s.name ILIKE '%' || $1 || '%'
+		ORDER BY LOWER(s.name)`, strings.TrimSpace(c.Query("q")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sellers"})
+		log.Println(err)
+		return
+	}
+	defer rows.Close()
+
+	sellers := []Seller{}
+	for rows.Next() {
+		var s Seller
+		if err := rows.Scan(&s.ID, &s.Name, &s.Kind, &s.URL, &s.Notes, &s.VinylCount); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error scanning data"})
+			log.Println(err)
+			return
+		}
+		sellers = append(sellers, s)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error iterating rows"})
+		return
+	}
+
+	c.JSON(http.StatusOK, sellers)
+}
+
+// CreateSeller adds a seller; names are unique regardless of case
+func CreateSeller(c *gin.Context) {
+	var s Seller
+	if err := c.ShouldBindJSON(&s); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	if err := validateSeller(&s); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	var existing int
+	err = db.QueryRow("SELECT id FROM sellers WHERE LOWER(name) = LOWER($1)", s.Name).Scan(&existing)
+	if err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Seller already exists", "id": existing})
+		return
+	}
+	if err != sql.ErrNoRows {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check sellers"})
+		log.Println(err)
+		return
+	}
+
+	err = db.QueryRow("INSERT INTO sellers (name, kind, url, notes) VALUES ($1, $2, $3, $4) RETURNING id",
+		s.Name, s.Kind, s.URL, s.Notes).Scan(&s.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert seller"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Seller created successfully", "id": s.ID})
+}
+
+// UpdateSeller edits a seller
+func UpdateSeller(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	var s Seller
+	if err := c.ShouldBindJSON(&s); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	if err := validateSeller(&s); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	var existing int
+	err = db.QueryRow("SELECT id FROM sellers WHERE LOWER(name) = LOWER($1) AND id <> $2", s.Name, id).Scan(&existing)
+	if err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Another seller has this name", "id": existing})
+		return
+	}
+	if err != sql.ErrNoRows {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check sellers"})
+		log.Println(err)
+		return
+	}
+
+	result, err := db.Exec("UPDATE sellers SET name = $1, kind = $2, url = $3, notes = $4 WHERE id = $5",
+		s.Name, s.Kind, s.URL, s.Notes, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update seller"})
+		log.Println(err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Seller not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Seller updated successfully", "id": id})
+}
+
+// DeleteSeller removes a seller no vinyl was bought from
+func DeleteSeller(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM vinyls WHERE seller_id = $1", id).Scan(&count); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check seller usage"})
+		log.Println(err)
+		return
+	}
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Seller is used by vinyls", "vinyls": count})
+		return
+	}
+
+	result, err := db.Exec("DELETE FROM sellers WHERE id = $1", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete seller"})
+		log.Println(err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Seller not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Seller id = %d deleted successfully", id)})
+}
+
+// SellerSpend is the spend at one seller, price plus shipping. Gifts and
+// records without a seller are grouped under a null SellerID.
+type SellerSpend struct {
+	SellerID *int `json:"seller_id"`
+	ValuationGroup
+	Shipping float64 `json:"shipping"` // converted shipping included in Converted
+}
+
+// GetSpendBySeller totals what was spent at each seller, including shipping,
+// per currency and in one target currency, largest first. It accepts the same
+// filters as the vinyl list.
+func GetSpendBySeller(c *gin.Context) {
+	rates, err := loadExchangeRates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load exchange rates"})
+		log.Println(err)
+		return
+	}
+	target, ok := valuationCurrency(c, rates)
+	if !ok {
+		return
+	}
+
+	filter, err := parseVinylFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT v.seller_id, COALESCE(s.name, ''), v.gift_from <> '', UPPER(TRIM(COALESCE(v.currency, ''))),
+			COALESCE(SUM(v.price), 0), COALESCE(SUM(v.shipping_cost), 0), COUNT(*)
+		FROM (SELECT seller_id, gift_from, currency, price, shipping_cost FROM vinyls`+filter.where()+`) v
+		LEFT JOIN sellers s ON s.id = v.seller_id
+		GROUP BY 1, 2, 3, 4`, filter.args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve data"})
+		log.Println(err)
+		return
+	}
+	defer rows.Close()
+
+	groups := map[string]*SellerSpend{}
+	total := &SellerSpend{ValuationGroup: ValuationGroup{Key: "total", ByCurrency: map[string]float64{}}}
+	for rows.Next() {
+		var sellerID sql.NullInt64
+		var name, currency string
+		var gift bool
+		var price, shipping float64
+		var count int
+		if err := rows.Scan(&sellerID, &name, &gift, &currency, &price, &shipping, &count); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error scanning data"})
+			log.Println(err)
+			return
+		}
+
+		key := name
+		if !sellerID.Valid {
+			key = "(unknown)"
+			if gift {
+				key = "(gift)"
+			}
+		}
+		g, ok := groups[key]
+		if !ok {
+			g = &SellerSpend{SellerID: nullableID(sellerID), ValuationGroup: ValuationGroup{Key: key, ByCurrency: map[string]float64{}}}
+			groups[key] = g
+		}
+		for _, s := range []*SellerSpend{g, total} {
+			s.add(count, price+shipping, currency, rates, target)
+			if converted, ok := rates.convert(shipping, currency, target); ok {
+				s.Shipping = roundMoney(s.Shipping + converted)
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error iterating rows"})
+		return
+	}
+
+	sellers := make([]SellerSpend, 0, len(groups))
+	for _, g := range groups {
+		sellers = append(sellers, *g)
+	}
+	sort.Slice(sellers, func(i, j int) bool {
+		if sellers[i].Converted != sellers[j].Converted {
+			return sellers[i].Converted > sellers[j].Converted
+		}
+		return sellers[i].Key < sellers[j].Key
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"currency":   target,
+		"rates_date": rates.Date,
+		"total":      total,
+		"sellers":    sellers,
+	})
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Tag kinds: genres are top-level, styles sit under a genre and user tags are free-form
+const (
+	tagKindGenre = "genre"
+	tagKindStyle = "style"
+	tagKindTag   = "tag"
+)
+
+type Tag struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	Kind       string `json:"kind"`
+	ParentID   *int   `json:"parent_id"`
+	VinylCount int    `json:"vinyl_count"`
+}
+
+// TagRef is the short form of a tag embedded in Vinyl
+type TagRef struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+// TagFacet is the number of matching vinyls carrying a tag
+type TagFacet struct {
+	TagRef
+	ParentID *int `json:"parent_id"`
+	Count    int  `json:"count"`
+}
+
+// tagSubtreeCondition matches vinyls tagged with the tag at placeholder or any of its descendants
+const tagSubtreeCondition = `id IN (SELECT vt.vinyl_id FROM vinyl_tags vt WHERE vt.tag_id IN (
+	WITH RECURSIVE subtree(id) AS (
+		SELECT %[1]s::integer
+		UNION
+		SELECT t.id FROM tags t JOIN subtree s ON t.parent_id = s.id
+	) SELECT id FROM subtree))`
+
+// attachVinylTags fills the Tags field of each vinyl
+func attachVinylTags(db dbExecutor, vinyls []Vinyl) error {
+	if len(vinyls) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(vinyls))
+	index := make(map[int]int, len(vinyls))
+	for i := range vinyls {
+		ids[i] = int64(vinyls[i].ID)
+		index[vinyls[i].ID] = i
+		vinyls[i].Tags = []TagRef{}
+	}
+
+	rows, err := db.Query(`SELECT vt.vinyl_id, t.id, t.name, t.kind
+		FROM vinyl_tags vt
+		JOIN tags t ON t.id = vt.tag_id
+		WHERE vt.vinyl_id = ANY($1)
+		ORDER BY vt.vinyl_id, t.kind, t.name`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var vinylID int
+		var tag TagRef
+		if err := rows.Scan(&vinylID, &tag.ID, &tag.Name, &tag.Kind); err != nil {
+			return err
+		}
+		i := index[vinylID]
+		vinyls[i].Tags = append(vinyls[i].Tags, tag)
+	}
+	return rows.Err()
+}
+
+// countTagFacets counts, for each tag, the vinyls matching filter that carry
+// it or one of its descendants, the same vinyls filtering by the tag returns
+func countTagFacets(db dbExecutor, filter *vinylFilter) ([]TagFacet, error) {
+	rows, err := db.Query(`WITH RECURSIVE closure(ancestor_id, tag_id) AS (
+			SELECT id, id FROM tags
+			UNION
+			SELECT c.ancestor_id, t.id FROM tags t JOIN closure c ON t.parent_id = c.tag_id
+		)
+		SELECT t.id, t.name, t.kind, t.parent_id, COUNT(DISTINCT vt.vinyl_id)
+		FROM closure c
+		JOIN vinyl_tags vt ON vt.tag_id = c.tag_id
+		JOIN tags t ON t.id = c.ancestor_id
+		WHERE vt.vinyl_id IN (SELECT id FROM vinyls`+filter.where()+`)
+		GROUP BY t.id, t.name, t.kind, t.parent_id
+		ORDER BY COUNT(DISTINCT vt.vinyl_id) DESC, t.name ASC`, filter.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	facets := []TagFacet{}
+	for rows.Next() {
+		var f TagFacet
+		var parentID sql.NullInt64
+		if err := rows.Scan(&f.ID, &f.Name, &f.Kind, &parentID, &f.Count); err != nil {
+			return nil, err
+		}
+		if parentID.Valid {
+			id := int(parentID.Int64)
+			f.ParentID = &id
+		}
+		facets = append(facets, f)
+	}
+	return facets, rows.Err()
+}
+
+// GetVinylFacets returns tag counts for the vinyls matching the list filters
+func GetVinylFacets(c *gin.Context) {
+	filter, err := parseVinylFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	facets, err := countTagFacets(db, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count tags"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tags": facets})
+}
+
+// GetTags lists tags, optionally of one kind, with the number of active vinyls carrying each
+func GetTags(c *gin.Context) {
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	query := `SELECT t.id, t.name, t.kind, t.parent_id,
+			(SELECT COUNT(*) FROM vinyl_tags vt JOIN vinyls v ON v.id = vt.vinyl_id
+				WHERE vt.tag_id = t.id AND v.status = 'active')
+		FROM tags t`
+	var args []interface{}
+	if kind := c.Query("kind"); kind != "" {
+		query += " WHERE t.kind = $1"
+		args = append(args, kind)
+	}
+	query += " ORDER BY t.kind, t.name"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve tags"})
+		log.Println(err)
+		return
+	}
+	defer rows.Close()
+
+	tags := []Tag{}
+	for rows.Next() {
+		var t Tag
+		var parentID sql.NullInt64
+		if err := rows.Scan(&t.ID, &t.Name, &t.Kind, &parentID, &t.VinylCount); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error scanning data"})
+			log.Println(err)
+			return
+		}
+		if parentID.Valid {
+			id := int(parentID.Int64)
+			t.ParentID = &id
+		}
+		tags = append(tags, t)
+	}
+
+	cachedJSON(c, tags)
+}
+
+// validateTagParent enforces the hierarchy: genres are top-level, styles belong
+// to a genre and user tags may nest under other user tags
+func validateTagParent(db dbExecutor, kind string, parentID *int) error {
+	switch kind {
+	case tagKindGenre:
+		if parentID != nil {
+			return fmt.Errorf("genres cannot have a parent")
+		}
+		return nil
+	case tagKindStyle, tagKindTag:
+	default:
+		return fmt.Errorf("kind must be genre, style or tag")
+	}
+
+	if parentID == nil {
+		if kind == tagKindStyle {
+			return fmt.Errorf("styles must have a parent genre")
+		}
+		return nil
+	}
+
+	var parentKind string
+	if err := db.QueryRow("SELECT kind FROM tags WHERE id = $1", *parentID).Scan(&parentKind); err != nil {
+		return fmt.Errorf("parent tag not found")
+	}
+	if kind == tagKindStyle && parentKind != tagKindGenre {
+		return fmt.Errorf("styles must have a parent genre")
+	}
+	if kind == tagKindTag && parentKind != tagKindTag {
+		return fmt.Errorf("user tags can only nest under user tags")
+	}
+	return nil
+}
+
+// CreateTag adds a genre, style or user tag
+func CreateTag(c *gin.Context) {
+	var req struct {
+		Name     string `json:"name"`
+		Kind     string `json:"kind"`
+		ParentID *int   `json:"parent_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Tag name cannot be empty"})
+		return
+	}
+	if req.Kind == "" {
+		req.Kind = tagKindTag
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	if err := validateTagParent(db, req.Kind, req.ParentID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var id int
+	err = db.QueryRow("INSERT INTO tags (name, kind, parent_id) VALUES ($1, $2, $3) RETURNING id", req.Name, req.Kind, req.ParentID).Scan(&id)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			c.JSON(http.StatusConflict, gin.H{"error": "Tag already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert tag"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tag created successfully", "id": id})
+}
+
+// UpdateTag renames a tag
+func UpdateTag(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Tag name cannot be empty"})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	result, err := db.Exec("UPDATE tags SET name = $1 WHERE id = $2", strings.TrimSpace(req.Name), id)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			c.JSON(http.StatusConflict, gin.H{"error": "Tag already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update tag"})
+		log.Println(err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tag updated successfully", "id": id})
+}
+
+// DeleteTag removes a tag, its descendants and their assignments
+func DeleteTag(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	result, err := db.Exec("DELETE FROM tags WHERE id = $1", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete tag"})
+		log.Println(err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Tag id = %d deleted successfully", id)})
+}
+
+// tagAssignment is the body of the bulk assign and remove endpoints
+type tagAssignment struct {
+	VinylIDs []int64 `json:"vinyl_ids"`
+	TagIDs   []int64 `json:"tag_ids"`
+}
+
+func bindTagAssignment(c *gin.Context) (tagAssignment, bool) {
+	var req tagAssignment
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.VinylIDs) == 0 || len(req.TagIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing vinyl_ids or tag_ids"})
+		return req, false
+	}
+	return req, true
+}
+
+// AssignTags adds every tag in tag_ids to every vinyl in vinyl_ids
+func AssignTags(c *gin.Context) {
+	req, ok := bindTagAssignment(c)
+	if !ok {
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	result, err := db.Exec(`INSERT INTO vinyl_tags (vinyl_id, tag_id)
+		SELECT v.id, t.id FROM vinyls v CROSS JOIN tags t
+		WHERE v.id = ANY($1) AND t.id = ANY($2)
+		ON CONFLICT DO NOTHING`, pq.Array(req.VinylIDs), pq.Array(req.TagIDs))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign tags"})
+		log.Println(err)
+		return
+	}
+	assigned, _ := result.RowsAffected()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tags assigned successfully", "assigned": assigned})
+}
+
+// RemoveTags removes every tag in tag_ids from every vinyl in vinyl_ids
+func RemoveTags(c *gin.Context) {
+	req, ok := bindTagAssignment(c)
+	if !ok {
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	result, err := db.Exec("DELETE FROM vinyl_tags WHERE vinyl_id = ANY($1) AND tag_id = ANY($2)", pq.Array(req.VinylIDs), pq.Array(req.TagIDs))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove tags"})
+		log.Println(err)
+		return
+	}
+	removed, _ := result.RowsAffected()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tags removed successfully", "removed": removed})
+}
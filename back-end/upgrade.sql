@@ -0,0 +1,231 @@
+-- Brings a database created from an older Postgresql.sql up to date.
+-- Every statement is idempotent, so the script can be run again after
+-- each update: psql -d your_db_name -f upgrade.sql
+
+ALTER TABLE vinyls
+    ADD COLUMN IF NOT EXISTS barcode VARCHAR(32) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS label VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS catalog_number VARCHAR(64) NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS vinyls_barcode_idx ON vinyls (barcode);
+CREATE INDEX IF NOT EXISTS vinyls_catalog_number_idx ON vinyls (LOWER(catalog_number));
+
+ALTER TABLE vinyls
+    ADD COLUMN IF NOT EXISTS country VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS release_date DATE,
+    ADD COLUMN IF NOT EXISTS rpm integer NOT NULL DEFAULT 0 CHECK (rpm IN (0, 33, 45, 78)),
+    ADD COLUMN IF NOT EXISTS disc_size integer NOT NULL DEFAULT 0 CHECK (disc_size IN (0, 7, 10, 12)),
+    ADD COLUMN IF NOT EXISTS color_variant VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS limited_number integer NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS limited_total integer NOT NULL DEFAULT 0;
+
+ALTER TABLE vinyls
+    ADD COLUMN IF NOT EXISTS media_condition VARCHAR(3) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS sleeve_condition VARCHAR(3) NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS condition_history (
+    id SERIAL PRIMARY KEY,
+    vinyl_id integer REFERENCES vinyls(id),
+    user_id integer REFERENCES users(id),
+    media_condition VARCHAR(3) NOT NULL DEFAULT '',
+    sleeve_condition VARCHAR(3) NOT NULL DEFAULT '',
+    note TEXT NOT NULL DEFAULT '',
+    changed_at timestamp with time zone DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS artists (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    sort_name VARCHAR(255) NOT NULL DEFAULT ''
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS artists_name_idx ON artists (LOWER(name));
+
+CREATE TABLE IF NOT EXISTS artist_aliases (
+    id SERIAL PRIMARY KEY,
+    artist_id integer NOT NULL REFERENCES artists(id) ON DELETE CASCADE,
+    alias VARCHAR(255) NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS artist_aliases_alias_idx ON artist_aliases (LOWER(alias));
+
+CREATE TABLE IF NOT EXISTS vinyl_artists (
+    vinyl_id integer NOT NULL REFERENCES vinyls(id),
+    artist_id integer NOT NULL REFERENCES artists(id),
+    role VARCHAR(32) NOT NULL DEFAULT 'main',
+    position integer NOT NULL DEFAULT 0,
+    PRIMARY KEY (vinyl_id, artist_id, role)
+);
+
+CREATE TABLE IF NOT EXISTS track_artists (
+    vinyl_id integer NOT NULL REFERENCES vinyls(id),
+    track_side VARCHAR(8) NOT NULL,
+    track_order integer NOT NULL,
+    artist_id integer NOT NULL REFERENCES artists(id),
+    role VARCHAR(32) NOT NULL DEFAULT 'featured',
+    PRIMARY KEY (vinyl_id, track_side, track_order, artist_id, role)
+);
+
+CREATE TABLE IF NOT EXISTS tags (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    kind VARCHAR(10) NOT NULL DEFAULT 'tag' CHECK (kind IN ('genre', 'style', 'tag')),
+    parent_id integer REFERENCES tags(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS tags_name_idx ON tags (kind, COALESCE(parent_id, 0), LOWER(name));
+
+CREATE TABLE IF NOT EXISTS vinyl_tags (
+    vinyl_id integer NOT NULL REFERENCES vinyls(id),
+    tag_id integer NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (vinyl_id, tag_id)
+);
+
+CREATE TABLE IF NOT EXISTS locations (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    kind VARCHAR(10) NOT NULL CHECK (kind IN ('room', 'shelf', 'box', 'slot')),
+    parent_id integer REFERENCES locations(id),
+    description TEXT NOT NULL DEFAULT ''
+);
+
+ALTER TABLE vinyls
+    ADD COLUMN IF NOT EXISTS location_id integer REFERENCES locations(id);
+
+CREATE INDEX IF NOT EXISTS vinyls_location_idx ON vinyls (location_id);
+
+CREATE TABLE IF NOT EXISTS location_moves (
+    id SERIAL PRIMARY KEY,
+    vinyl_id integer NOT NULL REFERENCES vinyls(id),
+    from_location_id integer REFERENCES locations(id) ON DELETE SET NULL,
+    to_location_id integer REFERENCES locations(id) ON DELETE SET NULL,
+    user_id integer REFERENCES users(id),
+    note TEXT NOT NULL DEFAULT '',
+    moved_at timestamp with time zone DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS audit_sessions (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    location_id integer REFERENCES locations(id) ON DELETE SET NULL,
+    user_id integer REFERENCES users(id),
+    started_at timestamp with time zone DEFAULT NOW(),
+    closed_at timestamp with time zone,
+    report JSONB
+);
+
+CREATE TABLE IF NOT EXISTS audit_scans (
+    id SERIAL PRIMARY KEY,
+    session_id integer NOT NULL REFERENCES audit_sessions(id) ON DELETE CASCADE,
+    vinyl_id integer REFERENCES vinyls(id),
+    barcode VARCHAR(32) NOT NULL DEFAULT '',
+    location_id integer REFERENCES locations(id) ON DELETE SET NULL,
+    user_id integer REFERENCES users(id),
+    scanned_at timestamp with time zone DEFAULT NOW(),
+    UNIQUE (session_id, vinyl_id)
+);
+
+CREATE TABLE IF NOT EXISTS loans (
+    id SERIAL PRIMARY KEY,
+    vinyl_id integer NOT NULL REFERENCES vinyls(id),
+    borrower_name VARCHAR(255) NOT NULL,
+    borrower_contact VARCHAR(255) NOT NULL DEFAULT '',
+    lent_at DATE NOT NULL DEFAULT CURRENT_DATE,
+    due_at DATE,
+    returned_at DATE,
+    note TEXT NOT NULL DEFAULT '',
+    user_id integer REFERENCES users(id)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS loans_open_idx ON loans (vinyl_id) WHERE returned_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS wishlist (
+    id SERIAL PRIMARY KEY,
+    release JSONB NOT NULL,
+    target_price DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    currency VARCHAR(10) NOT NULL DEFAULT '',
+    priority integer NOT NULL DEFAULT 3 CHECK (priority BETWEEN 1 AND 5),
+    notes TEXT NOT NULL DEFAULT '',
+    user_id integer REFERENCES users(id),
+    added_at timestamp with time zone DEFAULT NOW(),
+    converted_at timestamp with time zone,
+    vinyl_id integer REFERENCES vinyls(id)
+);
+
+CREATE TABLE IF NOT EXISTS price_observations (
+    id SERIAL PRIMARY KEY,
+    vinyl_id integer NOT NULL REFERENCES vinyls(id),
+    observed_at DATE NOT NULL DEFAULT CURRENT_DATE,
+    value DECIMAL(10, 2) NOT NULL,
+    currency VARCHAR(10) NOT NULL,
+    source VARCHAR(255) NOT NULL DEFAULT '',
+    note TEXT NOT NULL DEFAULT '',
+    user_id integer REFERENCES users(id),
+    created_at timestamp with time zone DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS price_observations_vinyl_idx ON price_observations (vinyl_id, observed_at DESC);
+
+CREATE TABLE IF NOT EXISTS sellers (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    kind VARCHAR(10) NOT NULL DEFAULT 'shop' CHECK (kind IN ('shop', 'online', 'market', 'person', 'other')),
+    url TEXT NOT NULL DEFAULT '',
+    notes TEXT NOT NULL DEFAULT ''
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS sellers_name_idx ON sellers (LOWER(name));
+
+ALTER TABLE vinyls
+    ADD COLUMN IF NOT EXISTS seller_id integer REFERENCES sellers(id),
+    ADD COLUMN IF NOT EXISTS order_id VARCHAR(100) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS shipping_cost DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS gift_from VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS acquisition_state VARCHAR(4) NOT NULL DEFAULT '' CHECK (acquisition_state IN ('', 'new', 'used'));
+
+ALTER TABLE vinyls
+    ADD COLUMN IF NOT EXISTS disposed_at DATE,
+    ADD COLUMN IF NOT EXISTS sale_price DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS sale_currency VARCHAR(10) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS disposed_to VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS disposal_note TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS images (
+    hash CHAR(64) PRIMARY KEY,
+    ext VARCHAR(5) NOT NULL,
+    width integer NOT NULL,
+    height integer NOT NULL,
+    size_bytes integer NOT NULL,
+    created_at timestamp with time zone DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS vinyl_images (
+    vinyl_id integer NOT NULL REFERENCES vinyls(id),
+    image_hash CHAR(64) NOT NULL REFERENCES images(hash),
+    PRIMARY KEY (vinyl_id, image_hash)
+);
+
+CREATE INDEX IF NOT EXISTS vinyl_images_hash_idx ON vinyl_images (image_hash);
+
+ALTER TABLE vinyl_images
+    ADD COLUMN IF NOT EXISTS kind VARCHAR(20) NOT NULL DEFAULT 'front' CHECK (kind IN ('front', 'back', 'inner_sleeve', 'label', 'insert', 'other')),
+    ADD COLUMN IF NOT EXISTS caption TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS position integer NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS is_primary boolean NOT NULL DEFAULT false,
+    ADD COLUMN IF NOT EXISTS added_at timestamp with time zone DEFAULT NOW();
+
+-- Images linked before there could be several per vinyl are its cover
+UPDATE vinyl_images vi SET is_primary = true
+FROM images i, vinyls v
+WHERE i.hash = vi.image_hash AND v.id = vi.vinyl_id
+    AND v.album_picture_url LIKE '%/' || i.hash || i.ext
+    AND NOT EXISTS (SELECT 1 FROM vinyl_images p WHERE p.vinyl_id = vi.vinyl_id AND p.is_primary);
+
+CREATE UNIQUE INDEX IF NOT EXISTS vinyl_images_primary_idx ON vinyl_images (vinyl_id) WHERE is_primary;
+
+ALTER TABLE images
+    ADD COLUMN IF NOT EXISTS palette TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS blurhash TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE images
+    ADD COLUMN IF NOT EXISTS phash BIGINT;
\ No newline at end of file
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// Upload limits, overridable with MAX_UPLOAD_MB and MAX_IMAGE_DIMENSION
+const (
+	defaultMaxUploadMB       = 20
+	defaultMaxImageDimension = 8000
+)
+
+// uploadError is a rejected upload and the status to answer with
+type uploadError struct {
+	status  int
+	message string
+}
+
+func (e *uploadError) Error() string { return e.message }
+
+// envInt reads a positive integer setting, falling back to def
+func envInt(name string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(name)); err == nil && n > 0 {
+		return n
+	}
+	return def
+}
+
+// maxUploadBytes is the largest accepted cover upload
+func maxUploadBytes() int64 {
+	return int64(envInt("MAX_UPLOAD_MB", defaultMaxUploadMB)) << 20
+}
+
+// sniffImageFormat identifies an image by its magic bytes. Only formats the
+// server can decode are recognised.
+func sniffImageFormat(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFF, 0xD8, 0xFF}):
+		return "jpeg"
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(head, []byte("GIF87a")), bytes.HasPrefix(head, []byte("GIF89a")):
+		return "gif"
+	}
+	return ""
+}
+
+// readAlbumUpload checks an uploaded cover and decodes it. The file type is
+// taken from its content, never from the client's filename, and dimensions
+// are checked before the pixels are decoded. Rejections are *uploadError.
+func readAlbumUpload(file *multipart.FileHeader) (image.Image, string, error) {
+	limit := maxUploadBytes()
+	if file.Size > limit {
+		return nil, "", &uploadError{http.StatusRequestEntityTooLarge, fmt.Sprintf("File is larger than %d MB", limit>>20)}
+	}
+	f, err := file.Open()
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, limit+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(len(data)) > limit {
+		return nil, "", &uploadError{http.StatusRequestEntityTooLarge, fmt.Sprintf("File is larger than %d MB", limit>>20)}
+	}
+
+	format := sniffImageFormat(data)
+	if format == "" {
+		return nil, "", &uploadError{http.StatusUnsupportedMediaType, "Unsupported file type, upload a JPEG, PNG or GIF image"}
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", &uploadError{http.StatusUnprocessableEntity, "Image file is damaged or incomplete"}
+	}
+	maxSide := envInt("MAX_IMAGE_DIMENSION", defaultMaxImageDimension)
+	if config.Width > maxSide || config.Height > maxSide {
+		return nil, "", &uploadError{http.StatusUnprocessableEntity,
+			fmt.Sprintf("Image is %dx%d pixels, the limit is %d on either side", config.Width, config.Height, maxSide)}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", &uploadError{http.StatusUnprocessableEntity, "Image file is damaged or incomplete"}
+	}
+	if format == "jpeg" {
+		img = applyOrientation(img, jpegOrientation(data))
+	}
+	return img, format, nil
+}
+
+// writeAlbumPicture re-encodes a cover, which drops EXIF and other metadata.
+// JPEGs stay JPEG; PNG and GIF become PNG. It returns the file extension.
+func writeAlbumPicture(w io.Writer, img image.Image, format string) (string, error) {
+	if format == "jpeg" {
+		return ".jpg", jpeg.Encode(w, img, &jpeg.Options{Quality: 92})
+	}
+	return ".png", png.Encode(w, img)
+}
+
+// jpegOrientation returns the EXIF orientation of a JPEG, 1 if it has none
+func jpegOrientation(data []byte) int {
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xDA || length < 2 || i+2+length > len(data) { // start of scan
+			break
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// exifOrientation reads tag 0x0112 from the first IFD of a TIFF structure
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for e := 0; e < entries; e++ {
+		entry := ifd + 2 + e*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			break
+		}
+	}
+	return 1
+}
+
+// applyOrientation turns an image upright according to its EXIF orientation,
+// since re-encoding drops the tag viewers would otherwise apply
+func applyOrientation(src image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = w-1-x, y
+			case 3: // upside down
+				sx, sy = w-1-x, h-1-y
+			case 4: // upside down and mirrored
+				sx, sy = x, h-1-y
+			case 5: // transposed
+				sx, sy = y, x
+			case 6: // rotated 90° clockwise
+				sx, sy = y, h-1-x
+			case 7: // transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // rotated 90° anticlockwise
+				sx, sy = w-1-y, x
+			}
+			dst.Set(x, y, src.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
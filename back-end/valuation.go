@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExchangeRates is the rate table read from EXCHANGE_RATES_FILE, e.g.
+//
+//	{"base": "EUR", "date": "2024-09-01", "rates": {"USD": 0.91, "SEK": 0.088}}
+//
+// Each rate is the value of one unit of the currency in the base currency.
+type ExchangeRates struct {
+	Base  string             `json:"base"`
+	Date  string             `json:"date"`
+	Rates map[string]float64 `json:"rates"`
+}
+
+// loadExchangeRates reads the rate table. Without a file only spend in
+// BASE_CURRENCY (USD by default) can be totalled.
+func loadExchangeRates() (ExchangeRates, error) {
+	rates := ExchangeRates{Base: os.Getenv("BASE_CURRENCY")}
+	if path := os.Getenv("EXCHANGE_RATES_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return rates, fmt.Errorf("reading exchange rates: %w", err)
+		}
+		if err := json.Unmarshal(data, &rates); err != nil {
+			return rates, fmt.Errorf("decoding exchange rates: %w", err)
+		}
+	}
+	if rates.Base == "" {
+		rates.Base = "USD"
+	}
+	rates.Base = strings.ToUpper(rates.Base)
+
+	normalized := map[string]float64{rates.Base: 1}
+	for currency, rate := range rates.Rates {
+		if rate <= 0 {
+			return rates, fmt.Errorf("exchange rate for %s must be positive", currency)
+		}
+		normalized[strings.ToUpper(currency)] = rate
+	}
+	rates.Rates = normalized
+	return rates, nil
+}
+
+// convert converts an amount between two currencies of the table, crossing
+// through the base currency. It reports false if either rate is unknown.
+func (r ExchangeRates) convert(amount float64, from, to string) (float64, bool) {
+	fromRate, ok := r.Rates[from]
+	if !ok {
+		return 0, false
+	}
+	toRate, ok := r.Rates[to]
+	if !ok {
+		return 0, false
+	}
+	return amount * fromRate / toRate, true
+}
+
+// roundMoney rounds an amount to cents
+func roundMoney(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// valuationCurrency resolves the currency a report is totalled in: ?currency=,
+// else BASE_CURRENCY, else the base of the rate table. It writes a 400
+// response and reports false if the table has no rate for it.
+func valuationCurrency(c *gin.Context, rates ExchangeRates) (string, bool) {
+	target := os.Getenv("BASE_CURRENCY")
+	if target == "" {
+		target = rates.Base
+	}
+	target = strings.ToUpper(strings.TrimSpace(c.DefaultQuery("currency", target)))
+	if _, ok := rates.Rates[target]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No exchange rate for " + target})
+		return "", false
+	}
+	return target, true
+}
+
+// ValuationGroup totals the purchase spend of a group of vinyls. Spend in a
+// currency missing from the rate table is listed in Unconverted instead of
+// being added to Converted.
+type ValuationGroup struct {
+	Key         string             `json:"key"`
+	Count       int                `json:"count"`
+	ByCurrency  map[string]float64 `json:"by_currency"`
+	Converted   float64            `json:"converted"`
+	Unconverted map[string]float64 `json:"unconverted,omitempty"`
+}
+
+func (g *ValuationGroup) add(count int, amount float64, currency string, rates ExchangeRates, target string) {
+	g.Count += count
+	g.ByCurrency[currency] = roundMoney(g.ByCurrency[currency] + amount)
+	if converted, ok := rates.convert(amount, currency, target); ok {
+		g.Converted = roundMoney(g.Converted + converted)
+		return
+	}
+	if g.Unconverted == nil {
+		g.Unconverted = map[string]float64{}
+	}
+	g.Unconverted[currency] = roundMoney(g.Unconverted[currency] + amount)
+}
+
+// valuationBreakdown accumulates groups keyed by one dimension
+type valuationBreakdown map[string]*ValuationGroup
+
+func (b valuationBreakdown) group(key string) *ValuationGroup {
+	if g, ok := b[key]; ok {
+		return g
+	}
+	g := &ValuationGroup{Key: key, ByCurrency: map[string]float64{}}
+	b[key] = g
+	return g
+}
+
+// sorted returns the groups by key, or by converted spend with the largest first
+func (b valuationBreakdown) sorted(byValue bool) []ValuationGroup {
+	groups := make([]ValuationGroup, 0, len(b))
+	for _, g := range b {
+		groups = append(groups, *g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if byValue && groups[i].Converted != groups[j].Converted {
+			return groups[i].Converted > groups[j].Converted
+		}
+		return groups[i].Key < groups[j].Key
+	})
+	return groups
+}
+
+// conditionKey is the breakdown key of a media grade, "ungraded" if none
+func conditionKey(grade string) string {
+	if grade == "" {
+		return "ungraded"
+	}
+	return grade
+}
+
+// GetValuation totals the purchase spend of the active collection per
+// currency and in one target currency, broken down by year bought, vinyl type,
+// artist and media condition. It accepts the same filters as the vinyl list.
+func GetValuation(c *gin.Context) {
+	rates, err := loadExchangeRates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load exchange rates"})
+		log.Println(err)
+		return
+	}
+	target, ok := valuationCurrency(c, rates)
+	if !ok {
+		return
+	}
+
+	filter, err := parseVinylFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`SELECT COALESCE(EXTRACT(YEAR FROM timebought)::integer, 0), COALESCE(vinyl_type, ''), COALESCE(artist, ''), media_condition,
+			UPPER(TRIM(COALESCE(currency, ''))), COALESCE(SUM(price), 0), COUNT(*)
+		FROM vinyls`+filter.where()+`
+		GROUP BY 1, 2, 3, 4, 5`, filter.args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve data"})
+		log.Println(err)
+		return
+	}
+	defer rows.Close()
+
+	total := &ValuationGroup{Key: "total", ByCurrency: map[string]float64{}}
+	byYear, byType, byArtist, byCondition := valuationBreakdown{}, valuationBreakdown{}, valuationBreakdown{}, valuationBreakdown{}
+	for rows.Next() {
+		var year, count int
+		var vinylType, artist, condition, currency string
+		var amount float64
+		if err := rows.Scan(&year, &vinylType, &artist, &condition, &currency, &amount, &count); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error scanning data"})
+			log.Println(err)
+			return
+		}
+
+		yearKey := "unknown"
+		if year > 0 {
+			yearKey = strconv.Itoa(year)
+		}
+		total.add(count, amount, currency, rates, target)
+		byYear.group(yearKey).add(count, amount, currency, rates, target)
+		byType.group(vinylType).add(count, amount, currency, rates, target)
+		byArtist.group(artist).add(count, amount, currency, rates, target)
+		byCondition.group(conditionKey(condition)).add(count, amount, currency, rates, target)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error iterating rows"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"currency":      target,
+		"rates_date":    rates.Date,
+		"total":         total,
+		"by_year":       byYear.sorted(false),
+		"by_vinyl_type": byType.sorted(false),
+		"by_artist":     byArtist.sorted(true),
+		"by_condition":  byCondition.sorted(false),
+	})
+}
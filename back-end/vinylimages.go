@@ -0,0 +1,494 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// imageKinds are the kinds of photo kept for a record
+var imageKinds = []string{"front", "back", "inner_sleeve", "label", "insert", "other"}
+
+func isImageKind(kind string) bool {
+	for _, k := range imageKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// VinylImage is one photo of a record. The primary image is the cover, and
+// its URL is mirrored in the vinyl's album_picture_url.
+type VinylImage struct {
+	Hash     string   `json:"hash"`
+	URL      string   `json:"url"`
+	Kind     string   `json:"kind"`
+	Caption  string   `json:"caption"`
+	Position int      `json:"position"`
+	Primary  bool     `json:"primary"`
+	Width    int      `json:"width"`
+	Height   int      `json:"height"`
+	Palette  []string `json:"palette"`
+	Blurhash string   `json:"blurhash"`
+}
+
+const vinylImageColumns = `vi.image_hash, i.ext, vi.kind, vi.caption, vi.position, vi.is_primary, i.width, i.height, i.palette, i.blurhash`
+
+func scanVinylImage(row rowScanner, extra ...interface{}) (VinylImage, error) {
+	var img VinylImage
+	var ext string
+	err := row.Scan(append(extra, &img.Hash, &ext, &img.Kind, &img.Caption, &img.Position, &img.Primary, &img.Width, &img.Height, pq.Array(&img.Palette), &img.Blurhash)...)
+	img.URL = albumImageURL(img.Hash, ext)
+	return img, err
+}
+
+// attachVinylImages loads the images of each vinyl in display order, and
+// the placeholders of its primary image
+func attachVinylImages(db dbExecutor, vinyls []Vinyl) error {
+	if len(vinyls) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(vinyls))
+	index := make(map[int]int, len(vinyls))
+	for i := range vinyls {
+		ids[i] = int64(vinyls[i].ID)
+		index[vinyls[i].ID] = i
+		vinyls[i].Images = []VinylImage{}
+	}
+
+	rows, err := db.Query(`SELECT vi.vinyl_id, `+vinylImageColumns+`
+		FROM vinyl_images vi
+		JOIN images i ON i.hash = vi.image_hash
+		WHERE vi.vinyl_id = ANY($1)
+		ORDER BY vi.vinyl_id, vi.position, vi.added_at`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var vinylID int
+		img, err := scanVinylImage(rows, &vinylID)
+		if err != nil {
+			return err
+		}
+		i := index[vinylID]
+		vinyls[i].Images = append(vinyls[i].Images, img)
+		if img.Primary {
+			vinyls[i].CoverPalette, vinyls[i].CoverBlurhash = img.Palette, img.Blurhash
+		}
+	}
+	return rows.Err()
+}
+
+// setPrimaryImage makes an attached image the vinyl's cover
+func setPrimaryImage(db dbExecutor, vinylID int, hash string) error {
+	// Demote first: the primary index is checked row by row
+	if _, err := db.Exec("UPDATE vinyl_images SET is_primary = false WHERE vinyl_id = $1 AND is_primary", vinylID); err != nil {
+		return err
+	}
+	var ext string
+	err := db.QueryRow(`UPDATE vinyl_images vi SET is_primary = true FROM images i
+		WHERE i.hash = vi.image_hash AND vi.vinyl_id = $1 AND vi.image_hash = $2
+		RETURNING i.ext`, vinylID, hash).Scan(&ext)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("UPDATE vinyls SET album_picture_url = $1 WHERE id = $2", albumImageURL(hash, ext), vinylID)
+	return err
+}
+
+// loadVinylImages returns the images of one vinyl in display order
+func loadVinylImages(db dbExecutor, vinylID int) ([]VinylImage, error) {
+	vinyls := []Vinyl{{ID: vinylID}}
+	err := attachVinylImages(db, vinyls)
+	return vinyls[0].Images, err
+}
+
+// activeVinylExists reports whether a vinyl exists and is not deleted
+func activeVinylExists(db dbExecutor, vinylID int) (bool, error) {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM vinyls WHERE id = $1 AND status <> 'deleted')", vinylID).Scan(&exists)
+	return exists, err
+}
+
+// GetVinylImages lists the images of a vinyl in display order
+func GetVinylImages(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	exists, err := activeVinylExists(db, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve data"})
+		log.Println(err)
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Vinyl not found"})
+		return
+	}
+
+	images, err := loadVinylImages(db, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve images"})
+		log.Println(err)
+		return
+	}
+	cachedJSON(c, images)
+}
+
+// vinylImageRequest is the body of AddVinylImage and UpdateVinylImage. Images
+// are uploaded with UploadAlbumPicture first and referred to by hash or URL.
+type vinylImageRequest struct {
+	Hash    string `json:"hash"`
+	URL     string `json:"url"`
+	Kind    string `json:"kind"`
+	Caption string `json:"caption"`
+	Primary bool   `json:"primary"`
+}
+
+func (r *vinylImageRequest) validate() error {
+	r.Kind = strings.ToLower(strings.TrimSpace(r.Kind))
+	if r.Kind == "" {
+		r.Kind = "other"
+	}
+	if !isImageKind(r.Kind) {
+		return fmt.Errorf("kind must be one of %s", strings.Join(imageKinds, ", "))
+	}
+	r.Caption = strings.TrimSpace(r.Caption)
+	return nil
+}
+
+// AddVinylImage attaches an uploaded image to a vinyl, after its other
+// images. An image added with primary becomes the cover, as does the first
+// image of a vinyl that has no cover at all or whose album_picture_url is
+// that image; any other album_picture_url is only replaced on request.
+func AddVinylImage(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	var req vinylImageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hash := strings.ToLower(strings.TrimSpace(req.Hash))
+	if hash == "" {
+		hash, _ = albumImageHash(req.URL)
+	}
+	if !isImageHash(hash) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "hash or url of an uploaded image is required"})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	exists, err := activeVinylExists(tx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve data"})
+		log.Println(err)
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Vinyl not found"})
+		return
+	}
+
+	var coverURL string
+	var hasPrimary bool
+	err = tx.QueryRow(`SELECT COALESCE(album_picture_url, ''),
+			EXISTS (SELECT 1 FROM vinyl_images WHERE vinyl_id = $1 AND is_primary)
+		FROM vinyls WHERE id = $1`, id).Scan(&coverURL, &hasPrimary)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve images"})
+		log.Println(err)
+		return
+	}
+
+	result, err := tx.Exec(`INSERT INTO vinyl_images (vinyl_id, image_hash, kind, caption, position, is_primary, added_at)
+		SELECT $1, hash, $3, $4, (SELECT COALESCE(MAX(position) + 1, 0) FROM vinyl_images WHERE vinyl_id = $1), false, NOW()
+		FROM images WHERE hash = $2
+		ON CONFLICT (vinyl_id, image_hash) DO NOTHING`, id, hash, req.Kind, req.Caption)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add image"})
+		log.Println(err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		var known bool
+		if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM images WHERE hash = $1)", hash).Scan(&known); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve images"})
+			log.Println(err)
+			return
+		}
+		if !known {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Image not found, upload it first"})
+			return
+		}
+		c.JSON(http.StatusConflict, gin.H{"error": "Image is already attached to this vinyl"})
+		return
+	}
+
+	coverHash, _ := albumImageHash(coverURL)
+	if req.Primary || (!hasPrimary && (coverURL == "" || coverHash == hash)) {
+		if err := setPrimaryImage(tx, id, hash); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set cover"})
+			log.Println(err)
+			return
+		}
+	}
+
+	images, err := loadVinylImages(tx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve images"})
+		log.Println(err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit image"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Image added successfully", "images": images})
+}
+
+// UpdateVinylImage changes the kind and caption of an image, and makes it the
+// cover when primary is set. The cover is changed by promoting another image.
+func UpdateVinylImage(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+	hash := c.Param("hash")
+
+	var req vinylImageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	var wasPrimary bool
+	err = tx.QueryRow(`UPDATE vinyl_images SET kind = $3, caption = $4 WHERE vinyl_id = $1 AND image_hash = $2
+		RETURNING is_primary`, id, hash, req.Kind, req.Caption).Scan(&wasPrimary)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found on this vinyl"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update image"})
+		log.Println(err)
+		return
+	}
+	if wasPrimary && !req.Primary {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A vinyl needs a cover, make another image primary instead"})
+		return
+	}
+	if req.Primary && !wasPrimary {
+		if err := setPrimaryImage(tx, id, hash); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set cover"})
+			log.Println(err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit image"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Image updated successfully"})
+}
+
+// ReorderVinylImages sets the display order of a vinyl's images. The body
+// lists every attached image hash in the new order.
+func ReorderVinylImages(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Hashes []string `json:"hashes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	current, err := loadVinylImages(tx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve images"})
+		log.Println(err)
+		return
+	}
+	attached := map[string]bool{}
+	for _, img := range current {
+		attached[img.Hash] = true
+	}
+	seen := map[string]bool{}
+	for _, hash := range req.Hashes {
+		if !attached[hash] || seen[hash] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "hashes must list each image of the vinyl once"})
+			return
+		}
+		seen[hash] = true
+	}
+	if len(seen) != len(attached) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "hashes must list each image of the vinyl once"})
+		return
+	}
+
+	for position, hash := range req.Hashes {
+		if _, err := tx.Exec("UPDATE vinyl_images SET position = $3 WHERE vinyl_id = $1 AND image_hash = $2", id, hash, position); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reorder images"})
+			log.Println(err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit order"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Images reordered successfully"})
+}
+
+// DeleteVinylImage detaches an image from a vinyl. Removing the cover
+// promotes the next image; a cover that is the vinyl's only image cannot be
+// removed. The file itself stays in the store, it may be shared with other
+// records.
+func DeleteVinylImage(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+	hash := c.Param("hash")
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	current, err := loadVinylImages(tx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve images"})
+		log.Println(err)
+		return
+	}
+	var removed *VinylImage
+	var next string
+	for i := range current {
+		if current[i].Hash == hash {
+			removed = &current[i]
+		} else if next == "" {
+			next = current[i].Hash
+		}
+	}
+	if removed == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found on this vinyl"})
+		return
+	}
+	if removed.Primary && next == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Cannot remove the only image of a vinyl, add a replacement first"})
+		return
+	}
+
+	if _, err := tx.Exec("DELETE FROM vinyl_images WHERE vinyl_id = $1 AND image_hash = $2", id, hash); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove image"})
+		log.Println(err)
+		return
+	}
+	if removed.Primary {
+		if err := setPrimaryImage(tx, id, next); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set cover"})
+			log.Println(err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit image removal"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Image removed successfully"})
+}
@@ -2,11 +2,13 @@ package main
 
 import (
 	"archive/zip"
+	"bytes"
 	"crypto/hmac"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -15,6 +17,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -28,6 +31,9 @@ type Track struct {
 	Order  int    `json:"order"`
 	Title  string `json:"title"`
 	Length string `json:"length"` // Length of the track, for example "4:20"
+	// Featured artists, stored in track_artists rather than in the tracklist JSON.
+	// Edits leave track credits alone unless a track sends this field.
+	Artists []ArtistCredit `json:"artists,omitempty"`
 }
 
 type Vinyl struct {
@@ -44,6 +50,104 @@ type Vinyl struct {
 	Price           float64 `json:"price"`
 	Currency        string  `json:"currency"`
 	Description     string  `json:"description"`
+	Barcode         string  `json:"barcode"`
+	Label           string  `json:"label"`
+	CatalogNumber   string  `json:"catalog_number"`
+	Country         string  `json:"country"`
+	ReleaseDate     string  `json:"release_date"` // exact release date, "2006-01-02" or empty
+	RPM             int     `json:"rpm"`          // 33, 45 or 78; 0 if unknown
+	DiscSize        int     `json:"disc_size"`    // 7, 10 or 12 inches; 0 if unknown
+	ColorVariant    string  `json:"color_variant"`
+	LimitedNumber   int     `json:"limited_number"`   // copy number of a numbered limited edition
+	LimitedTotal    int     `json:"limited_total"`    // edition size, 0 if not limited
+	MediaCondition  string  `json:"media_condition"`  // Goldmine grade, empty if ungraded
+	SleeveCondition string  `json:"sleeve_condition"` // Goldmine grade, empty if ungraded
+	LocationID      *int    `json:"location_id"`      // where the record is stored, null if unassigned
+	OnLoan          bool    `json:"on_loan"`          // read-only, derived from open loans
+	// Acquisition details; ShippingCost is in Currency like Price
+	SellerID         *int    `json:"seller_id"`
+	OrderID          string  `json:"order_id"`
+	ShippingCost     float64 `json:"shipping_cost"`
+	GiftFrom         string  `json:"gift_from"`         // who gave it to us, empty if bought
+	AcquisitionState string  `json:"acquisition_state"` // "new", "used" or empty if unknown
+	// Artists links the release to artist entities; Artist stays the display credit
+	Artists []ArtistCredit `json:"artists"`
+	Tags    []TagRef       `json:"tags"`
+	Images  []VinylImage   `json:"images"`
+	// Placeholders of the primary image, shown while the cover loads
+	CoverPalette  []string `json:"cover_palette,omitempty"`
+	CoverBlurhash string   `json:"cover_blurhash,omitempty"`
+}
+
+// vinylColumns is the column list read by scanVinyl, in scan order
+const vinylColumns = `id, title, artist, year, vinyl_type, vinyl_number, tracklist, album_picture_url, play_num, timebought, price, currency, description, barcode, label, catalog_number, country, COALESCE(TO_CHAR(release_date, 'YYYY-MM-DD'), ''), rpm, disc_size, color_variant, limited_number, limited_total, media_condition, sleeve_condition, location_id, seller_id, order_id, shipping_cost, gift_from, acquisition_state, ` + onLoanCondition
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// dbExecutor is implemented by both *sql.DB and *sql.Tx
+type dbExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// scanVinyl scans a row selected with vinylColumns and decodes its tracklist
+func scanVinyl(row rowScanner) (Vinyl, error) {
+	var v Vinyl
+	var tracklistJSON []byte // temporary variable to hold the raw JSON data
+	var locationID, sellerID sql.NullInt64
+
+	if err := row.Scan(&v.ID, &v.Title, &v.Artist, &v.Year, &v.VinylType, &v.VinylNumber, &tracklistJSON, &v.AlbumPictureURL, &v.PlayNum, &v.Timebought, &v.Price, &v.Currency, &v.Description, &v.Barcode, &v.Label, &v.CatalogNumber, &v.Country, &v.ReleaseDate, &v.RPM, &v.DiscSize, &v.ColorVariant, &v.LimitedNumber, &v.LimitedTotal, &v.MediaCondition, &v.SleeveCondition, &locationID, &sellerID, &v.OrderID, &v.ShippingCost, &v.GiftFrom, &v.AcquisitionState, &v.OnLoan); err != nil {
+		return v, err
+	}
+	v.LocationID = nullableID(locationID)
+	v.SellerID = nullableID(sellerID)
+
+	// Unmarshal tracklist JSON into the Tracklist field in the Vinyl struct
+	if err := json.Unmarshal(tracklistJSON, &v.Tracklist); err != nil {
+		return v, fmt.Errorf("decoding tracklist: %w", err)
+	}
+	return v, nil
+}
+
+// attachVinylDetails loads the related rows shown alongside each vinyl in list responses
+func attachVinylDetails(db dbExecutor, vinyls []Vinyl) error {
+	if err := attachVinylArtists(db, vinyls); err != nil {
+		return err
+	}
+	if err := attachVinylTags(db, vinyls); err != nil {
+		return err
+	}
+	return attachVinylImages(db, vinyls)
+}
+
+// encodeTracklist converts the tracklist to JSON for the tracklist column.
+// Track artists live in track_artists and are left out.
+func encodeTracklist(tracks []Track) ([]byte, error) {
+	stored := make([]Track, len(tracks))
+	for i, track := range tracks {
+		track.Artists = nil
+		stored[i] = track
+	}
+	return json.Marshal(stored)
+}
+
+// paramID parses the :id route parameter, writing a 400 response if it is not a number
+func paramID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid id"})
+		return 0, false
+	}
+	return id, true
+}
+
+// normalizeBarcode strips the spaces and dashes barcodes are often printed with
+func normalizeBarcode(barcode string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(barcode))
 }
 
 type PlayHistory struct {
@@ -55,8 +159,9 @@ type PlayHistory struct {
 
 // Load environment variables from the .env file
 func loadEnvVariables() {
+	// Without a .env file the variables come from the environment
 	err := godotenv.Load()
-	if err != nil {
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		log.Fatal("Error loading .env file")
 	}
 }
@@ -80,8 +185,21 @@ func connectDB() (*sql.DB, error) {
 	return sql.Open("postgres", connStr)
 }
 
-// GetVinylInfo retrieves the vinyl collection from the database
+// GetVinylInfo retrieves the vinyl collection from the database. With
+// ?facets=true the list is wrapped as {vinyls, total, facets} with the tag
+// counts of the matching vinyls, as returned by the search.
 func GetVinylInfo(c *gin.Context) {
+	filter, err := parseVinylFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	withFacets, _, err := queryBool(c, "facets")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	db, err := connectDB()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
@@ -89,7 +207,7 @@ func GetVinylInfo(c *gin.Context) {
 	}
 	defer db.Close()
 
-	rows, err := db.Query("SELECT id, title, artist, year, vinyl_type, vinyl_number, tracklist, album_picture_url, play_num, timebought, price, currency, description FROM vinyls where status = 'active' ORDER BY id ASC")
+	rows, err := db.Query("SELECT "+vinylColumns+" FROM vinyls"+filter.where()+" ORDER BY id ASC", filter.args...)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve data"})
 		return
@@ -98,27 +216,75 @@ func GetVinylInfo(c *gin.Context) {
 
 	var vinyls []Vinyl
 	for rows.Next() {
-		var v Vinyl
-		var tracklistJSON []byte // temporary variable to hold the raw JSON data
-
-		// Scan the row data; tracklist is retrieved as []byte
-		if err := rows.Scan(&v.ID, &v.Title, &v.Artist, &v.Year, &v.VinylType, &v.VinylNumber, &tracklistJSON, &v.AlbumPictureURL, &v.PlayNum, &v.Timebought, &v.Price, &v.Currency, &v.Description); err != nil {
+		v, err := scanVinyl(rows)
+		if err != nil {
 			fmt.Printf("Error scanning data: %v\n", err) // Print scan error details
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error scanning data"})
 			return
 		}
+		vinyls = append(vinyls, v)
+	}
 
-		// Unmarshal tracklist JSON into the Tracklist field in the Vinyl struct
-		if err := json.Unmarshal(tracklistJSON, &v.Tracklist); err != nil {
-			fmt.Printf("Error unmarshaling tracklist JSON: %v\n", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding tracklist"})
-			return
+	if err := attachVinylDetails(db, vinyls); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve vinyl details"})
+		log.Println(err)
+		return
+	}
+
+	if !withFacets {
+		cachedJSON(c, vinyls)
+		return
+	}
+	facets, err := countTagFacets(db, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count tags"})
+		log.Println(err)
+		return
+	}
+	cachedJSON(c, gin.H{"vinyls": vinyls, "total": len(vinyls), "facets": gin.H{"tags": facets}})
+}
+
+// insertVinyl stores a validated vinyl as an active record with its initial
+// grading, location and artist links, and sets its ID
+func insertVinyl(tx dbExecutor, vinyl *Vinyl, userID int) error {
+	// Convert the tracklist to JSON
+	tracklistJSON, err := encodeTracklist(vinyl.Tracklist)
+	if err != nil {
+		return fmt.Errorf("encoding tracklist: %w", err)
+	}
+
+	vinyl.Barcode = normalizeBarcode(vinyl.Barcode)
+	vinyl.CatalogNumber = strings.TrimSpace(vinyl.CatalogNumber)
+
+	if err := validateVinylReferences(tx, vinyl); err != nil {
+		return err
+	}
+
+	// Insert data into the vinyls table
+	query := `INSERT INTO vinyls (title, artist, year, vinyl_type, vinyl_number, tracklist, album_picture_url, play_num, timebought, price, currency, description, barcode, label, catalog_number, country, release_date, rpm, disc_size, color_variant, limited_number, limited_total, media_condition, sleeve_condition, location_id, seller_id, order_id, shipping_cost, gift_from, acquisition_state, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, NULLIF($17, '')::date, $18, $19, $20, $21, $22, $23, $24, $25, $26, $27, $28, $29, $30, 'active') RETURNING id`
+
+	err = tx.QueryRow(query, vinyl.Title, vinyl.Artist, vinyl.Year, vinyl.VinylType, vinyl.VinylNumber, tracklistJSON, vinyl.AlbumPictureURL, vinyl.PlayNum, vinyl.Timebought, vinyl.Price, vinyl.Currency, vinyl.Description, vinyl.Barcode, vinyl.Label, vinyl.CatalogNumber, vinyl.Country, vinyl.ReleaseDate, vinyl.RPM, vinyl.DiscSize, vinyl.ColorVariant, vinyl.LimitedNumber, vinyl.LimitedTotal, vinyl.MediaCondition, vinyl.SleeveCondition, vinyl.LocationID, vinyl.SellerID, vinyl.OrderID, vinyl.ShippingCost, vinyl.GiftFrom, vinyl.AcquisitionState).Scan(&vinyl.ID)
+	if err != nil {
+		return err
+	}
+
+	if vinyl.MediaCondition != "" || vinyl.SleeveCondition != "" {
+		if err := recordConditionChange(tx, vinyl.ID, userID, vinyl.MediaCondition, vinyl.SleeveCondition, "Initial grading"); err != nil {
+			return fmt.Errorf("recording condition history: %w", err)
 		}
+	}
 
-		vinyls = append(vinyls, v)
+	if vinyl.LocationID != nil {
+		if err := recordLocationMove(tx, vinyl.ID, nil, vinyl.LocationID, userID, "Added to collection"); err != nil {
+			return fmt.Errorf("recording location move: %w", err)
+		}
 	}
 
-	c.JSON(http.StatusOK, vinyls)
+	if err := linkVinylImage(tx, vinyl.ID, vinyl.AlbumPictureURL); err != nil {
+		return err
+	}
+	return setVinylArtists(tx, vinyl.ID, *vinyl)
 }
 
 // AddVinyl adds a new vinyl record to the database
@@ -138,88 +304,117 @@ func AddVinyl(c *gin.Context) {
 		log.Println(err)
 		return
 	}
+	if err := validateVinyl(&vinyl); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	// Convert the tracklist to JSON
-	tracklistJSON, err := json.Marshal(vinyl.Tracklist)
+	tx, err := db.Begin()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode tracklist"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
 		return
 	}
+	defer tx.Rollback()
 
-	// Insert data into the vinyls table
-	query := `INSERT INTO vinyls (title, artist, year, vinyl_type, vinyl_number, tracklist, album_picture_url, play_num, timebought, price, currency, description, status)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, 'active') RETURNING id`
-
-	err = db.QueryRow(query, vinyl.Title, vinyl.Artist, vinyl.Year, vinyl.VinylType, vinyl.VinylNumber, tracklistJSON, vinyl.AlbumPictureURL, vinyl.PlayNum, vinyl.Timebought, vinyl.Price, vinyl.Currency, vinyl.Description).Scan(&vinyl.ID)
-
-	if err != nil {
+	if err := insertVinyl(tx, &vinyl, c.MustGet("user_id").(int)); err != nil {
+		if message, ok := vinylInputError(err); ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": message})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert vinyl"})
 		// show error info in console
 		log.Println(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Vinyl added successfully", "id": vinyl.ID})
-}
-
-// UploadAlbumPicture handles the album picture upload
-func UploadAlbumPicture(c *gin.Context) {
-
-	// Retrieve title, artist, vinyl type, and number of vinyls from form data
-	title := c.PostForm("title")
-	artist := c.PostForm("artist")
-	vinylType := c.PostForm("vinyl_type")
-	vinylNumber := c.PostForm("vinyl_number")
-
-	// Ensure that title, artist, vinyl type, and number of vinyls are provided
-	if title == "" || artist == "" || vinylType == "" || vinylNumber == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing title, artist, vinyl type, or number of vinyls"})
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert vinyl"})
+		log.Println(err)
 		return
 	}
 
-	// Retrieve the file from form data
+	// Owning two copies is allowed, but the client should know about it
+	warnings, err := findDuplicateIdentifiers(db, vinyl)
+	if err != nil {
+		log.Println(err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Vinyl added successfully", "id": vinyl.ID, "warnings": warnings})
+}
+
+// UploadAlbumPicture handles the album picture upload. The picture is stored
+// under the hash of its content, so identical uploads share one file and the
+// URL does not depend on the record's title. Active records whose cover looks
+// the same are returned as warnings.
+func UploadAlbumPicture(c *gin.Context) {
+	// Retrieve the file from form data, refusing oversized bodies early
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadBytes()+1<<20)
 	file, err := c.FormFile("album_picture")
 	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("File is larger than %d MB", maxUploadBytes()>>20)})
+			return
+		}
 		// for debug
 		fmt.Println(err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
 		return
 	}
 
-	// Define the album directory
-	albumDir := "./album"
-	if _, err := os.Stat(albumDir); os.IsNotExist(err) {
-		// Create the album directory if it doesn't exist
-		err = os.Mkdir(albumDir, os.ModePerm)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create album directory"})
+	// Check the content and decode it; the client's extension is not trusted
+	img, format, err := readAlbumUpload(file)
+	if err != nil {
+		var rejected *uploadError
+		if errors.As(err, &rejected) {
+			c.JSON(rejected.status, gin.H{"error": rejected.message})
 			return
 		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+		log.Println(err)
+		return
 	}
 
-	// Sanitize title and artist to avoid invalid file characters
-	safeTitle := sanitizeFilename(title)
-	safeArtist := sanitizeFilename(artist)
-
-	// Extract file extension
-	extension := filepath.Ext(file.Filename)
+	// Re-encode to strip metadata
+	var encoded bytes.Buffer
+	extension, err := writeAlbumPicture(&encoded, img, format)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode image"})
+		log.Println(err)
+		return
+	}
 
-	// Generate safe filename
-	filename := fmt.Sprintf("%s_%s(%s%s)%s", safeTitle, safeArtist, vinylNumber, vinylType, extension)
-	filePath := filepath.Join(albumDir, filename)
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
 
-	// Save file
-	if err := c.SaveUploadedFile(file, filePath); err != nil {
+	stored, existed, err := storeImage(c.Request.Context(), db, encoded.Bytes(), extension, img)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+		log.Println(err)
 		return
 	}
 
-	// Escape filename for URL safety
-	album_base_url := os.Getenv("ALBUM_BASE_URL")
-	escapedFilename := url.PathEscape(filename)
-	fileURL := fmt.Sprintf("%s/api/album/%s", album_base_url, escapedFilename)
+	// Resized copies for thumbnails and grids; ServeAlbumPicture regenerates
+	// any that are missing, so a failure here is not fatal
+	if !existed {
+		if err := generateAlbumVariants(c.Request.Context(), imageFilename(stored.Hash, stored.Ext), img); err != nil {
+			log.Println(err)
+		}
+	}
+
+	// Warn about records that already show this artwork, which are often the
+	// same release entered twice under a different title
+	warnings, err := similarCovers(db, stored.PHash, defaultDuplicateDistance)
+	if err != nil {
+		warnings = []SimilarCover{}
+		log.Println(err)
+	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "File uploaded successfully", "url": fileURL})
+	c.JSON(http.StatusOK, gin.H{"message": "File uploaded successfully", "url": albumImageURL(stored.Hash, stored.Ext), "image": stored, "duplicate": existed, "warnings": warnings})
 }
 
 func DeleteVinyl(c *gin.Context) {
@@ -238,15 +433,6 @@ func DeleteVinyl(c *gin.Context) {
 		return
 	}
 
-	// check if trash folder exists, if not, create it
-	if _, err := os.Stat("./album/trash"); os.IsNotExist(err) {
-		err = os.Mkdir("./album/trash", os.ModePerm)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create trash directory"})
-			return
-		}
-	}
-
 	// delete the album picture, not real delete, just put it in the trash folder
 	// get the album picture url
 	var albumPictureURL string
@@ -264,8 +450,20 @@ func DeleteVinyl(c *gin.Context) {
 		fmt.Println(err)
 		return
 	}
-	// move the file to trash folder
-	os.Rename("./album/"+filename, "./album/trash/"+filename)
+	// move the file to trash folder, unless another record shows the same picture
+	inUse, err := pictureInUse(db, albumPictureURL, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check album picture usage"})
+		fmt.Println(err)
+		return
+	}
+	if !inUse {
+		ctx := c.Request.Context()
+		if err := albumStore.Rename(ctx, albumKey(filename), albumTrashKey(filename)); err != nil && err != errBlobNotFound {
+			log.Println(err)
+		}
+		removeAlbumVariants(ctx, filename)
+	}
 
 	_, err = db.Exec("update vinyls set status = 'deleted' where id = $1", id)
 	if err != nil {
@@ -308,6 +506,26 @@ func AddPlayNum(c *gin.Context) {
 		return
 	}
 
+	// A record lent to someone cannot be played here
+	onLoan, err := isOnLoan(db, vinyl_id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check loans"})
+		fmt.Println(err)
+		return
+	}
+	if onLoan {
+		c.JSON(http.StatusConflict, gin.H{"error": "Vinyl is on loan"})
+		return
+	}
+
+	// Neither can one that left the collection
+	var status sql.NullString
+	err = db.QueryRow("SELECT status FROM vinyls WHERE id = $1", vinyl_id).Scan(&status)
+	if err == nil && isDisposalState(status.String) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Vinyl is no longer in the collection", "status": status.String})
+		return
+	}
+
 	// First, record play information
 	var playID int
 	if user_id != 0 && play_time != "" {
@@ -362,18 +580,51 @@ func UpdateVinyl(c *gin.Context) {
 		log.Println(err)
 		return
 	}
+	if err := validateVinyl(&vinyl); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	// Convert the tracklist to JSON
-	tracklistJSON, err := json.Marshal(vinyl.Tracklist)
+	tracklistJSON, err := encodeTracklist(vinyl.Tracklist)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode tracklist"})
 		return
 	}
 
+	vinyl.Barcode = normalizeBarcode(vinyl.Barcode)
+	vinyl.CatalogNumber = strings.TrimSpace(vinyl.CatalogNumber)
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	if err := validateVinylReferences(tx, &vinyl); err != nil {
+		if message, ok := vinylInputError(err); ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": message})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve data"})
+		log.Println(err)
+		return
+	}
+
 	// Insert data into the vinyls table
-	query := `UPDATE vinyls SET title = $1, artist = $2, year = $3, vinyl_type = $4, vinyl_number = $5, tracklist = $6, album_picture_url = $7, play_num = $8, timebought = $9, price = $10, currency = $11, description = $12 WHERE id = $13`
+	query := `UPDATE vinyls SET title = $1, artist = $2, year = $3, vinyl_type = $4, vinyl_number = $5, tracklist = $6, album_picture_url = $7, play_num = $8, timebought = $9, price = $10, currency = $11, description = $12, barcode = $13, label = $14, catalog_number = $15, country = $16, release_date = NULLIF($17, '')::date, rpm = $18, disc_size = $19, color_variant = $20, limited_number = $21, limited_total = $22, media_condition = $23, sleeve_condition = $24, location_id = $25, seller_id = $26, order_id = $27, shipping_cost = $28, gift_from = $29, acquisition_state = $30 WHERE id = $31`
 
-	_, err = db.Exec(query, vinyl.Title, vinyl.Artist, vinyl.Year, vinyl.VinylType, vinyl.VinylNumber, tracklistJSON, vinyl.AlbumPictureURL, vinyl.PlayNum, vinyl.Timebought, vinyl.Price, vinyl.Currency, vinyl.Description, id)
+	// Remember the previous grading, location and artist so changes through the edit form are logged and linked too
+	var oldMedia, oldSleeve, oldArtist string
+	var oldLocation sql.NullInt64
+	if err := tx.QueryRow("SELECT media_condition, sleeve_condition, location_id, COALESCE(artist, '') FROM vinyls WHERE id = $1", id).Scan(&oldMedia, &oldSleeve, &oldLocation, &oldArtist); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve data"})
+		log.Println(err)
+		return
+	}
+
+	_, err = tx.Exec(query, vinyl.Title, vinyl.Artist, vinyl.Year, vinyl.VinylType, vinyl.VinylNumber, tracklistJSON, vinyl.AlbumPictureURL, vinyl.PlayNum, vinyl.Timebought, vinyl.Price, vinyl.Currency, vinyl.Description, vinyl.Barcode, vinyl.Label, vinyl.CatalogNumber, vinyl.Country, vinyl.ReleaseDate, vinyl.RPM, vinyl.DiscSize, vinyl.ColorVariant, vinyl.LimitedNumber, vinyl.LimitedTotal, vinyl.MediaCondition, vinyl.SleeveCondition, vinyl.LocationID, vinyl.SellerID, vinyl.OrderID, vinyl.ShippingCost, vinyl.GiftFrom, vinyl.AcquisitionState, id)
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update vinyl"})
@@ -382,31 +633,97 @@ func UpdateVinyl(c *gin.Context) {
 		return
 	}
 
+	if vinyl.MediaCondition != oldMedia || vinyl.SleeveCondition != oldSleeve {
+		if err := recordConditionChange(tx, id, c.MustGet("user_id").(int), vinyl.MediaCondition, vinyl.SleeveCondition, ""); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record condition history"})
+			log.Println(err)
+			return
+		}
+	}
+
+	if from := nullableID(oldLocation); !sameLocation(from, vinyl.LocationID) {
+		if err := recordLocationMove(tx, id, from, vinyl.LocationID, c.MustGet("user_id").(int), ""); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record location move"})
+			log.Println(err)
+			return
+		}
+	}
+
+	if err := linkVinylImage(tx, id, vinyl.AlbumPictureURL); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link album picture"})
+		log.Println(err)
+		return
+	}
+
+	if !linkVinylArtists(c, tx, id, vinyl, oldArtist) {
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update vinyl"})
+		log.Println(err)
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Vinyl updated successfully", "id": id})
 }
 
+// ServeAlbumPicture serves a cover. ?size= selects a resized variant (150,
+// 400 or 1000 px, small, medium or large; other numbers pick the next larger
+// variant) and ?format=webp a WebP variant instead of JPEG. With presigned
+// reads enabled the client is redirected to the object store. Hashed covers
+// are sent with a strong ETag and may be cached indefinitely.
 func ServeAlbumPicture(c *gin.Context) {
 	filename := c.Param("filename")
-	filePath := filepath.Join("./album", filename)
+	ctx := c.Request.Context()
+
+	size, err := albumVariantSize(c.Query("size"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	format := "jpg"
+	switch strings.ToLower(c.Query("format")) {
+	case "", "jpg", "jpeg":
+	case "webp":
+		format = "webp"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be jpeg or webp"})
+		return
+	}
 
 	// 检查文件是否存在
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+	if _, err := albumStore.Stat(ctx, albumKey(filename)); err == errBlobNotFound {
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+		log.Println(err)
+		return
 	}
 
-	// 通过 Gin 发送文件
-	c.File(filePath)
-}
+	// A hashed cover that still exists cannot have changed since the client fetched it
+	etag, cacheControl := albumCacheHeaders(filename, size, format)
+	if etag != "" && etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Header("ETag", etag)
+		c.Header("Cache-Control", cacheControl)
+		c.Status(http.StatusNotModified)
+		return
+	}
 
-// sanitizeFilename 移除或替换文件名中的非法字符
-func sanitizeFilename(name string) string {
-	// 过滤掉不安全字符，如 / \ : * ? " < > |
-	illegalChars := []string{"/", "\\", ":", "*", "?", "\"", "<", ">", "|"}
-	for _, char := range illegalChars {
-		name = strings.ReplaceAll(name, char, "_")
+	if size > 0 {
+		variant, err := ensureAlbumVariant(ctx, filename, size, format)
+		if err == nil {
+			serveBlob(c, variant, etag, cacheControl)
+			return
+		}
+		// Covers that cannot be decoded are served as uploaded
+		log.Println(err)
+		etag, cacheControl = albumCacheHeaders(filename, 0, format)
 	}
-	return name
+
+	// 通过 Gin 发送文件
+	serveBlob(c, albumKey(filename), etag, cacheControl)
 }
 
 func GetVinylByID(c *gin.Context) {
@@ -425,22 +742,27 @@ func GetVinylByID(c *gin.Context) {
 		return
 	}
 
-	var v Vinyl
-	var tracklistJSON []byte
-
-	err = db.QueryRow("SELECT id, title, artist, year, vinyl_type, vinyl_number, tracklist, album_picture_url, play_num, timebought, price, currency, description FROM vinyls WHERE id = $1", id).Scan(&v.ID, &v.Title, &v.Artist, &v.Year, &v.VinylType, &v.VinylNumber, &tracklistJSON, &v.AlbumPictureURL, &v.PlayNum, &v.Timebought, &v.Price, &v.Currency, &v.Description)
+	v, err := scanVinyl(db.QueryRow("SELECT "+vinylColumns+" FROM vinyls WHERE id = $1", id))
 	if err != nil {
+		fmt.Printf("Error retrieving vinyl: %v\n", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve data"})
 		return
 	}
 
-	if err := json.Unmarshal(tracklistJSON, &v.Tracklist); err != nil {
-		fmt.Printf("Error unmarshaling tracklist JSON: %v\n", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding tracklist"})
+	vinyls := []Vinyl{v}
+	if err := attachVinylDetails(db, vinyls); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve vinyl details"})
+		log.Println(err)
+		return
+	}
+	v = vinyls[0]
+	if err := attachTrackArtists(db, &v); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve track artists"})
+		log.Println(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, v)
+	cachedJSON(c, v)
 }
 
 func GetPlayHistoryByID(c *gin.Context) {
@@ -460,23 +782,12 @@ func GetPlayHistoryByID(c *gin.Context) {
 	defer db.Close()
 
 	// Retrieve vinyl info based on the provided ID
-	var v Vinyl
-	var tracklistJSON []byte
-	err = db.QueryRow(`
-		SELECT id, title, artist, year, vinyl_type, vinyl_number, tracklist, album_picture_url, play_num, timebought, price, currency, description 
-		FROM vinyls 
-		WHERE id = $1`, id).Scan(
-		&v.ID, &v.Title, &v.Artist, &v.Year, &v.VinylType, &v.VinylNumber, &tracklistJSON,
-		&v.AlbumPictureURL, &v.PlayNum, &v.Timebought, &v.Price, &v.Currency, &v.Description)
+	v, err := scanVinyl(db.QueryRow("SELECT "+vinylColumns+" FROM vinyls WHERE id = $1", id))
 	if err != nil {
+		fmt.Printf("Error retrieving vinyl: %v\n", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve data"})
 		return
 	}
-	if err := json.Unmarshal(tracklistJSON, &v.Tracklist); err != nil {
-		fmt.Printf("Error unmarshaling tracklist JSON: %v\n", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error decoding tracklist"})
-		return
-	}
 
 	// Retrieve play history with usernames
 	var playHistory []PlayHistory
@@ -535,16 +846,14 @@ func Backup(c *gin.Context) {
 		return
 	}
 
-	// 2. Copy album folder using Linux cp -r
-	albumDir := "./album"
+	// 2. Export the album art from the blob store
 	albumBackupDir := filepath.Join(backupDir, "album")
 	if err := os.MkdirAll(albumBackupDir, os.ModePerm); err != nil {
 		os.RemoveAll(backupDir)
 		c.JSON(500, gin.H{"error": "Failed to create album backup directory"})
 		return
 	}
-	cmd := exec.Command("cp", "-r", albumDir+"/.", albumBackupDir)
-	if err := cmd.Run(); err != nil {
+	if err := exportBlobs(c.Request.Context(), albumStore, "album/", albumBackupDir); err != nil {
 		os.RemoveAll(backupDir)
 		c.JSON(500, gin.H{"error": "Failed to copy album folder: " + err.Error()})
 		return
@@ -803,13 +1112,13 @@ func Restore(c *gin.Context) {
 
 		// 4. Restore album folder
 		albumBackupDir := filepath.Join(actualRestoreDir, "album")
-		albumDir := "./album"
-		if err := os.RemoveAll(albumDir); err != nil {
+		ctx := c.Request.Context()
+		if err := clearBlobs(ctx, albumStore, "album/"); err != nil {
 			log.Println("Failed to clear album folder:", err)
 			c.JSON(500, gin.H{"error": "Failed to clear album folder" + err.Error()})
 			return
 		}
-		if err := copyDir(albumBackupDir, albumDir); err != nil {
+		if err := importBlobs(ctx, albumStore, albumBackupDir, "album/"); err != nil {
 			log.Println("Failed to restore album folder:", err)
 			c.JSON(500, gin.H{"error": "Failed to restore album folder: "})
 			return
@@ -875,32 +1184,3 @@ func unzipFile(src, dest string) error {
 	}
 	return nil
 }
-
-// Helper: copy a directory recursively
-func copyDir(src, dest string) error {
-	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		rel, err := filepath.Rel(src, path)
-		if err != nil {
-			return err
-		}
-		destPath := filepath.Join(dest, rel)
-		if info.IsDir() {
-			return os.MkdirAll(destPath, info.Mode())
-		}
-		srcFile, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		defer srcFile.Close()
-		destFile, err := os.Create(destPath)
-		if err != nil {
-			return err
-		}
-		defer destFile.Close()
-		_, err = io.Copy(destFile, srcFile)
-		return err
-	})
-}
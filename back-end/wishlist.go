@@ -0,0 +1,387 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WishlistItem is a record we want to buy. Release holds its metadata in the
+// same shape as an owned vinyl, so it can come straight from a metadata provider.
+type WishlistItem struct {
+	ID          int     `json:"id"`
+	Release     Vinyl   `json:"release"`
+	TargetPrice float64 `json:"target_price"`
+	Currency    string  `json:"currency"`
+	Priority    int     `json:"priority"` // 1 (most wanted) to 5
+	Notes       string  `json:"notes"`
+	AddedAt     string  `json:"added_at"`
+	VinylID     *int    `json:"vinyl_id"` // the owned vinyl once converted
+}
+
+// wishlistRelease keeps only the metadata of a vinyl, dropping the fields that
+// describe a copy we own
+func wishlistRelease(v Vinyl) Vinyl {
+	v.ID = 0
+	v.PlayNum = 0
+	v.Timebought = ""
+	v.Price = 0
+	v.Currency = ""
+	v.MediaCondition = ""
+	v.SleeveCondition = ""
+	v.LocationID = nil
+	v.OnLoan = false
+	v.Tags = nil
+	v.SellerID = nil
+	v.OrderID = ""
+	v.ShippingCost = 0
+	v.GiftFrom = ""
+	v.AcquisitionState = ""
+	return v
+}
+
+// validateWishlistItem checks and normalises an item before it is stored
+func validateWishlistItem(item *WishlistItem) error {
+	item.Release = wishlistRelease(item.Release)
+	item.Release.Title = strings.TrimSpace(item.Release.Title)
+	if item.Release.Title == "" {
+		return fmt.Errorf("release title cannot be empty")
+	}
+	if err := validateVinyl(&item.Release); err != nil {
+		return err
+	}
+	item.Release.Barcode = normalizeBarcode(item.Release.Barcode)
+	if item.Priority == 0 {
+		item.Priority = 3
+	}
+	if item.Priority < 1 || item.Priority > 5 {
+		return fmt.Errorf("priority must be between 1 and 5")
+	}
+	if item.TargetPrice < 0 {
+		return fmt.Errorf("target_price cannot be negative")
+	}
+	item.Notes = strings.TrimSpace(item.Notes)
+	return nil
+}
+
+const wishlistColumns = `id, release, target_price, currency, priority, notes, added_at, vinyl_id`
+
+func scanWishlistItem(row rowScanner) (WishlistItem, error) {
+	var item WishlistItem
+	var releaseJSON []byte
+	var vinylID sql.NullInt64
+	if err := row.Scan(&item.ID, &releaseJSON, &item.TargetPrice, &item.Currency, &item.Priority, &item.Notes, &item.AddedAt, &vinylID); err != nil {
+		return item, err
+	}
+	item.VinylID = nullableID(vinylID)
+	if err := json.Unmarshal(releaseJSON, &item.Release); err != nil {
+		return item, fmt.Errorf("decoding wishlist release: %w", err)
+	}
+	return item, nil
+}
+
+// GetWishlist lists the wishlist by priority. Converted entries are only
+// included with all=true.
+func GetWishlist(c *gin.Context) {
+	all, _, err := queryBool(c, "all")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	query := "SELECT " + wishlistColumns + " FROM wishlist"
+	if !all {
+		query += " WHERE vinyl_id IS NULL"
+	}
+	rows, err := db.Query(query + " ORDER BY priority ASC, added_at ASC, id ASC")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve wishlist"})
+		log.Println(err)
+		return
+	}
+	defer rows.Close()
+
+	items := []WishlistItem{}
+	for rows.Next() {
+		item, err := scanWishlistItem(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error scanning data"})
+			log.Println(err)
+			return
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error iterating rows"})
+		return
+	}
+
+	c.JSON(http.StatusOK, items)
+}
+
+// GetWishlistItem returns one wishlist entry
+func GetWishlistItem(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	item, err := scanWishlistItem(db.QueryRow("SELECT "+wishlistColumns+" FROM wishlist WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Wishlist item not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve wishlist item"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, item)
+}
+
+// AddWishlistItem adds a record to the wishlist
+func AddWishlistItem(c *gin.Context) {
+	var item WishlistItem
+	if err := c.ShouldBindJSON(&item); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		log.Println(err)
+		return
+	}
+	if err := validateWishlistItem(&item); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	releaseJSON, err := json.Marshal(item.Release)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode release"})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	err = db.QueryRow(`INSERT INTO wishlist (release, target_price, currency, priority, notes, user_id, added_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW()) RETURNING id`,
+		releaseJSON, item.TargetPrice, item.Currency, item.Priority, item.Notes, c.MustGet("user_id").(int)).Scan(&item.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert wishlist item"})
+		log.Println(err)
+		return
+	}
+
+	// Let the client know if we already own a copy
+	warnings, err := findDuplicateIdentifiers(db, item.Release)
+	if err != nil {
+		log.Println(err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Wishlist item added successfully", "id": item.ID, "warnings": warnings})
+}
+
+// UpdateWishlistItem replaces a wishlist entry that has not been converted yet
+func UpdateWishlistItem(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	var item WishlistItem
+	if err := c.ShouldBindJSON(&item); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		log.Println(err)
+		return
+	}
+	if err := validateWishlistItem(&item); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	releaseJSON, err := json.Marshal(item.Release)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode release"})
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	result, err := db.Exec(`UPDATE wishlist SET release = $1, target_price = $2, currency = $3, priority = $4, notes = $5
+		WHERE id = $6 AND vinyl_id IS NULL`, releaseJSON, item.TargetPrice, item.Currency, item.Priority, item.Notes, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update wishlist item"})
+		log.Println(err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Wishlist item not found or already converted"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Wishlist item updated successfully", "id": id})
+}
+
+// DeleteWishlistItem removes a record we no longer want
+func DeleteWishlistItem(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	result, err := db.Exec("DELETE FROM wishlist WHERE id = $1", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete wishlist item"})
+		log.Println(err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Wishlist item not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Wishlist item id = %d deleted successfully", id)})
+}
+
+// ConvertWishlistItem records the purchase of a wishlist entry: it adds the
+// release to the collection as an owned vinyl and marks the entry converted,
+// in one transaction. Price defaults to the target price and timebought to
+// now; the acquisition details can be given at the same time.
+func ConvertWishlistItem(c *gin.Context) {
+	id, ok := paramID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Timebought       string   `json:"timebought"`
+		Price            *float64 `json:"price"`
+		Currency         string   `json:"currency"`
+		MediaCondition   string   `json:"media_condition"`
+		SleeveCondition  string   `json:"sleeve_condition"`
+		LocationID       *int     `json:"location_id"`
+		SellerID         *int     `json:"seller_id"`
+		OrderID          string   `json:"order_id"`
+		ShippingCost     float64  `json:"shipping_cost"`
+		GiftFrom         string   `json:"gift_from"`
+		AcquisitionState string   `json:"acquisition_state"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+			return
+		}
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to connect to the database"})
+		return
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	item, err := scanWishlistItem(tx.QueryRow("SELECT "+wishlistColumns+" FROM wishlist WHERE id = $1 FOR UPDATE", id))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Wishlist item not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve wishlist item"})
+		log.Println(err)
+		return
+	}
+	if item.VinylID != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Wishlist item already converted", "vinyl_id": *item.VinylID})
+		return
+	}
+
+	vinyl := item.Release
+	vinyl.Timebought = strings.TrimSpace(req.Timebought)
+	if vinyl.Timebought == "" {
+		vinyl.Timebought = time.Now().Format(time.RFC3339)
+	}
+	vinyl.Price = item.TargetPrice
+	if req.Price != nil {
+		vinyl.Price = *req.Price
+	}
+	vinyl.Currency = item.Currency
+	if req.Currency != "" {
+		vinyl.Currency = req.Currency
+	}
+	vinyl.MediaCondition = req.MediaCondition
+	vinyl.SleeveCondition = req.SleeveCondition
+	vinyl.LocationID = req.LocationID
+	vinyl.SellerID = req.SellerID
+	vinyl.OrderID = req.OrderID
+	vinyl.ShippingCost = req.ShippingCost
+	vinyl.GiftFrom = req.GiftFrom
+	vinyl.AcquisitionState = req.AcquisitionState
+	if err := validateVinyl(&vinyl); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := insertVinyl(tx, &vinyl, c.MustGet("user_id").(int)); err != nil {
+		if message, ok := vinylInputError(err); ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": message})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert vinyl"})
+		log.Println(err)
+		return
+	}
+
+	if _, err := tx.Exec("UPDATE wishlist SET vinyl_id = $1, converted_at = NOW() WHERE id = $2", vinyl.ID, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update wishlist item"})
+		log.Println(err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert wishlist item"})
+		log.Println(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Wishlist item converted successfully", "id": vinyl.ID, "wishlist_id": id})
+}
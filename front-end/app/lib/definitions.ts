@@ -1,10 +1,17 @@
 import moment from "moment-timezone";
 
+export type ArtistCredit = {
+    artist_id: number;
+    name: string;
+    role?: string;
+}
+
 export type Track = {
     side: string;
     order: number;
     title: string;
     length: string;
+    artists?: ArtistCredit[];
 }
 
 export type Vinyl = {
@@ -21,6 +28,74 @@ export type Vinyl = {
     price: number;
     currency: string;
     description: string;
+    barcode?: string;
+    label?: string;
+    catalog_number?: string;
+    country?: string;
+    release_date?: string;
+    rpm?: number;
+    disc_size?: number;
+    color_variant?: string;
+    limited_number?: number;
+    limited_total?: number;
+    media_condition?: string;
+    sleeve_condition?: string;
+    artists?: ArtistCredit[];
+    tags?: TagRef[];
+    images?: VinylImage[];
+    cover_palette?: string[];
+    cover_blurhash?: string;
+    location_id?: number | null;
+    on_loan?: boolean;
+    seller_id?: number | null;
+    order_id?: string;
+    shipping_cost?: number;
+    gift_from?: string;
+    acquisition_state?: string;
+}
+
+export type Disposal = {
+    status: 'sold' | 'traded' | 'gifted' | 'lost' | 'broken';
+    disposed_at: string;
+    sale_price: number;
+    sale_currency: string;
+    disposed_to: string;
+    note: string;
+}
+
+export type DisposedVinyl = Vinyl & {
+    disposal: Disposal;
+}
+
+// coverURL asks the backend for a resized cover; other URLs are returned unchanged
+export const coverURL = (url: string, size: 'small' | 'medium' | 'large'): string =>
+    url.includes('/api/album/') ? `${url}${url.includes('?') ? '&' : '?'}size=${size}` : url;
+
+export type SimilarCover = {
+    vinyl_id: number;
+    title: string;
+    artist: string;
+    url: string;
+    distance: number;
+}
+
+export type VinylImage = {
+    hash: string;
+    url: string;
+    kind: 'front' | 'back' | 'inner_sleeve' | 'label' | 'insert' | 'other';
+    caption: string;
+    position: number;
+    primary: boolean;
+    width: number;
+    height: number;
+    palette: string[];
+    blurhash: string;
+}
+
+export type TagRef = {
+    id: number;
+    name: string;
+    kind: 'genre' | 'style' | 'tag';
 }
 
 export const majorCities = [
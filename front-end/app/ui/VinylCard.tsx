@@ -1,6 +1,6 @@
 import React from 'react';
 import Image from 'next/image';
-import { Vinyl } from '@/app/lib/definitions';
+import { Vinyl, coverURL } from '@/app/lib/definitions';
 import { useTranslation } from 'react-i18next';
 
 const VinylCard: React.FC<{ vinyl: Vinyl }> = ({ vinyl }) => {
@@ -27,9 +27,12 @@ const VinylCard: React.FC<{ vinyl: Vinyl }> = ({ vinyl }) => {
     return (
         <div className="w-80 h-[580px] flex flex-col rounded-lg overflow-hidden shadow-lg bg-white border border-[#ddd] hover:shadow-xl transition-shadow duration-200">
             {/* Album Cover */}
-            <div className="bg-white h-72 w-full flex items-center justify-center">
+            <div
+                className="bg-white h-72 w-full flex items-center justify-center"
+                style={{ backgroundColor: vinyl.cover_palette?.[0] }}
+            >
                 <Image
-                    src={vinyl.album_picture_url}
+                    src={coverURL(vinyl.album_picture_url, 'medium')}
                     alt={vinyl.title}
                     width={320}
                     height={288}
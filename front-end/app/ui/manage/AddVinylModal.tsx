@@ -1,4 +1,4 @@
-import { Vinyl, Track } from '@/app/lib/definitions';
+import { Vinyl, Track, SimilarCover } from '@/app/lib/definitions';
 import { useState, ChangeEvent, useRef, useCallback } from 'react';
 import Image from 'next/image';
 import MacOSTrafficLights from '@/app/ui/MacOSTrafficLights';
@@ -71,12 +71,6 @@ export default function AddVinylModal({ onClose, onSave }: AddVinylModalProps) {
         const formData = new FormData();
         formData.append('album_picture', e.target.files[0]);
 
-        // Add the required form fields
-        formData.append('title', newVinyl.title);
-        formData.append('artist', newVinyl.artist);
-        formData.append('vinyl_type', newVinyl.vinyl_type);
-        formData.append('vinyl_number', newVinyl.vinyl_number.toString());
-
         try {
             const res = await fetch(`${BACKEND_URL}/api/upload`, {
                 method: 'POST',
@@ -89,6 +83,10 @@ export default function AddVinylModal({ onClose, onSave }: AddVinylModalProps) {
 
             if (data.url) {
                 setNewVinyl((prev) => ({ ...prev, album_picture_url: data.url }));
+                if (data.warnings?.length) {
+                    const records = data.warnings.map((w: SimilarCover) => `${w.title} - ${w.artist}`);
+                    showAlert(`${m('similar_cover_warning')}\n${records.join('\n')}`);
+                }
             } else {
                 showAlert(`Upload failed: ${data.error || 'No URL returned'}`);
             }
@@ -96,7 +94,7 @@ export default function AddVinylModal({ onClose, onSave }: AddVinylModalProps) {
             console.error(error);
             showAlert('Upload failed');
         }
-    }, [newVinyl.title, newVinyl.artist, newVinyl.vinyl_type, newVinyl.vinyl_number, showAlert]);
+    }, [showAlert, m]);
 
     const handleTrackChange = useCallback((index: number, field: keyof Track, value: string | number) => {
         const updatedTracklist = [...newVinyl.tracklist];
@@ -241,7 +239,6 @@ export default function AddVinylModal({ onClose, onSave }: AddVinylModalProps) {
         handleTrackChange(index, 'length', `${minutes}:${formattedSeconds}`);
     }, [newVinyl.tracklist, handleTrackChange]);
 
-    const isUploadDisabled = !newVinyl.title || !newVinyl.artist || !newVinyl.vinyl_type || !newVinyl.vinyl_number;
     const isSaveDisabled = !newVinyl.title || !newVinyl.artist;
 
     const currencyOptions = [
@@ -309,26 +306,15 @@ export default function AddVinylModal({ onClose, onSave }: AddVinylModalProps) {
                         />
                     </div>
                     <div className="flex flex-col items-start gap-2 mt-auto">
-                        <label
-                            className={`${isUploadDisabled
-                                ? 'bg-gray-400 cursor-not-allowed'
-                                : 'bg-blue-600 hover:bg-blue-700'
-                                } text-white px-4 py-2 rounded-lg`}
-                        >
+                        <label className="bg-blue-600 hover:bg-blue-700 text-white px-4 py-2 rounded-lg cursor-pointer">
                             {m('choose_file')}
                             <input
                                 type="file"
                                 accept="image/*"
                                 className="hidden"
                                 onChange={handleFileChange}
-                                disabled={isUploadDisabled}
                             />
                         </label>
-                        {isUploadDisabled && (
-                            <p className="text-xs text-red-500 max-w-[200px]">
-                                {m('cover_upload_warning')}
-                            </p>
-                        )}
                     </div>
                 </div>
 
@@ -28,12 +28,6 @@ export default function EditVinylModal({ vinyl, onClose, onSave }: EditVinylModa
             // Use the correct field name that matches the backend expectation
             formData.append('album_picture', e.target.files[0]);
 
-            // Add the required fields from the current edited vinyl data
-            formData.append('title', editedVinyl.title);
-            formData.append('artist', editedVinyl.artist);
-            formData.append('vinyl_type', editedVinyl.vinyl_type);
-            formData.append('vinyl_number', editedVinyl.vinyl_number.toString());
-
             try {
                 const res = await fetch(`${process.env.NEXT_PUBLIC_BACKEND_URL}/api/upload`, {
                     method: 'POST',
@@ -1,6 +1,6 @@
 import React, { useState } from 'react';
 import Image from 'next/image';
-import { Vinyl } from '@/app/lib/definitions';
+import { Vinyl, coverURL } from '@/app/lib/definitions';
 import { useTranslation } from 'react-i18next';
 import CheckCircleIcon from '@mui/icons-material/CheckCircle';
 import RadioButtonUncheckedIcon from '@mui/icons-material/RadioButtonUnchecked';
@@ -79,7 +79,7 @@ const VinylItem: React.FC<VinylItemProps> = ({
                             aspectRatio: '1'
                         }}>
                         <Image
-                            src={vinyl.album_picture_url}
+                            src={coverURL(vinyl.album_picture_url, 'small')}
                             alt={vinyl.title}
                             width={70}
                             height={70}